@@ -0,0 +1,233 @@
+package otfreader
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+//
+// tar files are identified by the ustar magic at this offset
+//
+const tarMagicOffset = 257
+
+var (
+	gzipMagic     = []byte{0x1f, 0x8b}
+	zipMagic      = []byte("PK\x03\x04")
+	zipEmptyMagic = []byte("PK\x05\x06")
+	tarMagic      = []byte("ustar")
+)
+
+//
+// file extensions that are always let through the watcher's
+// suffix filter, so that archives can be opened and their
+// members filtered by suffix instead
+//
+var archiveSuffixes = []string{"zip", "gz", "tgz", "tar"}
+
+//
+// reads the input stream, transparently unpacking gzip, zip
+// and tar files (including combinations such as .tar.gz).
+// each member of an archive is read with the configured input
+// format if its name matches the watcher file suffix, and its
+// name is added to the meta-data of every record as archiveMember.
+//
+// name is the file or member name of the stream, member is the
+// path of the stream within the archive ("" for the file itself),
+// and gunzipped is true if the stream has been decompressed from
+// a plain gzip file, in which case its own name is the member name.
+//
+func (rdr *OtfReader) readArchive(r io.Reader, name string, member string, gunzipped bool, handler recordHandler) error {
+
+	br := bufio.NewReader(r)
+	head, err := br.Peek(tarMagicOffset + len(tarMagic))
+	if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+		return errors.Wrap(err, "unable to read start of file")
+	}
+
+	switch {
+	case bytes.HasPrefix(head, gzipMagic):
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return errors.Wrap(err, "unable to open gzip file")
+		}
+		defer gz.Close()
+		return rdr.readArchive(gz, gzipInnerName(name, gz.Header.Name), member, true, handler)
+
+	case bytes.HasPrefix(head, zipMagic) || bytes.HasPrefix(head, zipEmptyMagic):
+		// zip needs random access, so use the file directly if
+		// possible, otherwise spool the stream to a temp file
+		ra, size, cleanup, err := readerAt(r, br)
+		if err != nil {
+			return errors.Wrap(err, "unable to access zip file")
+		}
+		defer cleanup()
+		zr, err := zip.NewReader(ra, size)
+		if err != nil {
+			return errors.Wrap(err, "unable to open zip file")
+		}
+		if rdr.inputFormat == "xlsx" && isWorkbook(zr) {
+			// xlsx files are zip files too
+			return rdr.readMember(io.NewSectionReader(ra, 0, size), name, member, gunzipped, handler)
+		}
+		return rdr.readZip(zr, member, handler)
+
+	case len(head) >= tarMagicOffset+len(tarMagic) && bytes.Equal(head[tarMagicOffset:], tarMagic):
+		return rdr.readTar(tar.NewReader(br), member, handler)
+	}
+
+	return rdr.readMember(br, name, member, gunzipped, handler)
+}
+
+//
+// reads every file in a zip archive
+//
+func (rdr *OtfReader) readZip(zr *zip.Reader, member string, handler recordHandler) error {
+
+	failed := 0
+	for _, zf := range zr.File {
+		if zf.FileInfo().IsDir() {
+			continue
+		}
+		rc, err := zf.Open()
+		if err == nil {
+			err = rdr.readArchive(rc, zf.Name, path.Join(member, zf.Name), false, handler)
+			rc.Close()
+		}
+		if err != nil {
+			failed++
+			log.Printf("error reading archive member %s: %v\n", path.Join(member, zf.Name), err)
+		}
+	}
+
+	return membersError(failed)
+}
+
+//
+// reads every regular file in a tar archive
+//
+func (rdr *OtfReader) readTar(tr *tar.Reader, member string, handler recordHandler) error {
+
+	failed := 0
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return errors.Wrap(err, "unable to read tar file")
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		err = rdr.readArchive(tr, hdr.Name, path.Join(member, hdr.Name), false, handler)
+		if err != nil {
+			failed++
+			log.Printf("error reading archive member %s: %v\n", path.Join(member, hdr.Name), err)
+		}
+	}
+
+	return membersError(failed)
+}
+
+//
+// reads a plain (non-archive) stream with the input format,
+// adding the member name to the meta-data if it came from
+// inside an archive
+//
+func (rdr *OtfReader) readMember(r io.Reader, name string, member string, gunzipped bool, handler recordHandler) error {
+
+	if gunzipped && member == "" {
+		member = path.Base(name)
+	}
+	if member == "" {
+		return rdr.readInput(r, name, handler)
+	}
+
+	if !rdr.matchesSuffix(name) {
+		fmt.Fprintf(rdr.out, "skipping archive member %s, does not match suffix %s\n", member, rdr.watchFileSuffix)
+		return nil
+	}
+
+	memberHandler := func(m []byte, meta ...metaField) error {
+		return handler(m, append(meta, metaField{name: "archiveMember", value: member})...)
+	}
+	return rdr.readInput(r, name, memberHandler)
+}
+
+//
+// the name of the file inside a gzip file, from the
+// gzip header if present, otherwise from the file name
+//
+func gzipInnerName(name string, headerName string) string {
+	if headerName != "" {
+		return headerName
+	}
+	lower := strings.ToLower(name)
+	switch {
+	case strings.HasSuffix(lower, ".tgz"):
+		return name[:len(name)-len(".tgz")] + ".tar"
+	case strings.HasSuffix(lower, ".gz"):
+		return name[:len(name)-len(".gz")]
+	}
+	return name
+}
+
+//
+// xlsx workbooks are zip files with a content types member
+//
+func isWorkbook(zr *zip.Reader) bool {
+	for _, zf := range zr.File {
+		if zf.Name == "[Content_Types].xml" {
+			return true
+		}
+	}
+	return false
+}
+
+//
+// provides random access to the stream, using the underlying
+// file if the stream is a file, otherwise spooling the
+// (buffered) stream to a temp file that is removed by cleanup
+//
+func readerAt(r io.Reader, br *bufio.Reader) (io.ReaderAt, int64, func(), error) {
+
+	if f, ok := r.(*os.File); ok {
+		if fi, err := f.Stat(); err == nil && fi.Mode().IsRegular() {
+			return f, fi.Size(), func() {}, nil
+		}
+	}
+
+	tmp, err := ioutil.TempFile("", "otf-reader-*.zip")
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	cleanup := func() {
+		tmp.Close()
+		os.Remove(tmp.Name())
+	}
+	size, err := io.Copy(tmp, br)
+	if err != nil {
+		cleanup()
+		return nil, 0, nil, err
+	}
+	return tmp, size, cleanup, nil
+}
+
+func membersError(failed int) error {
+	if failed > 0 {
+		return errors.Errorf("%d archive member(s) could not be read", failed)
+	}
+	return nil
+}
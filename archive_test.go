@@ -0,0 +1,192 @@
+package otfreader
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+//
+// archive members, in order
+//
+type testMember struct {
+	name    string
+	content []byte
+}
+
+func gzipBytes(t *testing.T, name string, content []byte) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	gz.Name = name
+	if _, err := gz.Write(content); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func zipBytes(t *testing.T, members ...testMember) []byte {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, m := range members {
+		w, err := zw.Create(m.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(m.content)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func tarBytes(t *testing.T, members ...testMember) []byte {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	tw.WriteHeader(&tar.Header{Name: "dir/", Typeflag: tar.TypeDir, Mode: 0755})
+	for _, m := range members {
+		hdr := &tar.Header{Name: m.name, Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(m.content))}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		tw.Write(m.content)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestReadArchive(t *testing.T) {
+
+	a := []byte("id\n1\n")
+	b := []byte("id\n2\n")
+
+	cases := []struct {
+		name    string
+		file    string
+		content []byte
+		suffix  string
+		records []string // archiveMember: record
+		err     string
+	}{
+		{
+			name:    "plain file",
+			file:    "results.csv",
+			content: a,
+			records: []string{`: {"id":"1"}`},
+		},
+		{
+			name:    "gzip named from file",
+			file:    "results.csv.gz",
+			content: gzipBytes(t, "", a),
+			records: []string{`results.csv: {"id":"1"}`},
+		},
+		{
+			name:    "gzip named from header",
+			file:    "upload.gz",
+			content: gzipBytes(t, "inner.csv", a),
+			records: []string{`inner.csv: {"id":"1"}`},
+		},
+		{
+			name:    "zip",
+			file:    "results.zip",
+			content: zipBytes(t, testMember{"a.csv", a}, testMember{"dir/", nil}, testMember{"dir/b.csv", b}),
+			records: []string{`a.csv: {"id":"1"}`, `dir/b.csv: {"id":"2"}`},
+		},
+		{
+			name:    "tar.gz",
+			file:    "results.tgz",
+			content: gzipBytes(t, "", tarBytes(t, testMember{"dir/a.csv", a}, testMember{"dir/b.csv", b})),
+			records: []string{`dir/a.csv: {"id":"1"}`, `dir/b.csv: {"id":"2"}`},
+		},
+		{
+			name:    "zip in a zip",
+			file:    "outer.zip",
+			content: zipBytes(t, testMember{"inner.zip", zipBytes(t, testMember{"a.csv", a})}),
+			records: []string{`inner.zip/a.csv: {"id":"1"}`},
+		},
+		{
+			name:    "gzip in a zip",
+			file:    "outer.zip",
+			content: zipBytes(t, testMember{"a.csv.gz", gzipBytes(t, "", a)}),
+			records: []string{`a.csv.gz: {"id":"1"}`},
+		},
+		{
+			name:    "members filtered by suffix",
+			file:    "results.zip",
+			content: zipBytes(t, testMember{"a.csv", a}, testMember{"notes.txt", []byte("notes\nnot a record\n")}),
+			suffix:  "csv",
+			records: []string{`a.csv: {"id":"1"}`},
+		},
+		{
+			name:    "bad member",
+			file:    "results.zip",
+			content: zipBytes(t, testMember{"a.csv", a}, testMember{"b.csv.gz", append(gzipMagic, "not gzip"...)}, testMember{"c.csv", b}),
+			records: []string{`a.csv: {"id":"1"}`, `c.csv: {"id":"2"}`},
+			err:     "1 archive member(s) could not be read",
+		},
+		{
+			name:    "empty zip",
+			file:    "empty.zip",
+			content: zipBytes(t),
+			records: []string{},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			rdr := newTestReader(t, &memPublisher{})
+			if c.suffix != "" {
+				rdr.suffixRegex = regexp.MustCompile(`\.` + c.suffix + `$`)
+			}
+			records := []string{}
+			handler := func(m []byte, meta ...metaField) error {
+				member := ""
+				for _, mf := range meta {
+					if mf.name == "archiveMember" {
+						member = fmt.Sprint(mf.value)
+					}
+				}
+				records = append(records, member+": "+string(m))
+				return nil
+			}
+
+			err := rdr.readArchive(bytes.NewReader(c.content), c.file, "", false, handler)
+			switch {
+			case c.err == "" && err != nil:
+				t.Fatal(err)
+			case c.err != "" && (err == nil || !strings.Contains(err.Error(), c.err)):
+				t.Fatalf("error is %v, expected %q", err, c.err)
+			}
+			if !reflect.DeepEqual(records, c.records) {
+				t.Errorf("records are\n%v\nexpected\n%v", records, c.records)
+			}
+		})
+	}
+}
+
+func TestGzipInnerName(t *testing.T) {
+
+	cases := [][3]string{
+		{"results.csv.gz", "", "results.csv"},
+		{"results.CSV.GZ", "", "results.CSV"},
+		{"bundle.tgz", "", "bundle.tar"},
+		{"upload.gz", "export.csv", "export.csv"},
+		{"upload", "", "upload"},
+	}
+	for _, c := range cases {
+		if name := gzipInnerName(c[0], c[1]); name != c[2] {
+			t.Errorf("%s (header %q) gives %s, expected %s", c[0], c[1], name, c[2])
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package otfreader
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/nsip/otf-reader/internal/util"
+)
+
+//
+// publishes files that were already in the watched folder when
+// the reader started (which the watcher does not report), in
+// path order, one at a time. depending on the backlog mode:
+//
+// all: every file is published, even if already in the ledger
+// unprocessed: files not published before according to the
+// ledger (or changed since) are published
+// none: existing files are left alone
+//
+func (rdr *OtfReader) publishBacklog() {
+
+	if rdr.backlog == "none" {
+		return
+	}
+	defer util.TimeTrack(time.Now(), "publishBacklog()")
+
+	watched := rdr.watcher.WatchedFiles()
+	paths := []string{}
+	for p, fi := range watched {
+		if fi.Mode().IsRegular() && !rdr.ignoredFile(p) {
+			paths = append(paths, p)
+		}
+	}
+	sort.Strings(paths)
+
+	fmt.Fprintf(rdr.out, "\nbacklog: %d existing file(s) in %s\n", len(paths), rdr.watchFolder)
+	if rdr.backlog == "unprocessed" && rdr.ledger == nil {
+		fmt.Fprintln(rdr.out, "backlog: no stateFolder ledger, so all files are treated as unprocessed")
+	}
+
+	published, skipped, waiting, failed := 0, 0, 0, 0
+	for _, p := range paths {
+		if rdr.backlog == "unprocessed" && rdr.unchangedSincePublished(p) {
+			skipped++
+			continue
+		}
+		if !rdr.settledNow(p) {
+			// still being written, or not marked as ready yet
+			rdr.publishWhenSettled(p)
+			waiting++
+			continue
+		}
+		if err := rdr.publishFile(p, rdr.backlog == "all"); err != nil {
+			log.Println("error publishing file: ", p, err)
+			failed++
+			continue
+		}
+		published++
+	}
+
+	fmt.Fprintf(rdr.out, "backlog: %d file(s) read, %d already published, %d waiting to settle, %d failed\n", published, skipped, waiting, failed)
+}
+
+//
+// true if the ledger shows the file was published and
+// its size and modification time have not changed since
+// (other files are hashed, and skipped if the content is
+// the same, when they are published)
+//
+func (rdr *OtfReader) unchangedSincePublished(path string) bool {
+
+	entry, found, err := rdr.ledger.get(path)
+	if err != nil {
+		log.Printf("Warning: unable to check ledger for %s: %v\n", path, err)
+		return false
+	}
+	if !found || entry.Outcome != outcomePublished {
+		return false
+	}
+	fi, ok := rdr.watcher.WatchedFiles()[path]
+	return ok && fi.Size() == entry.Size && fi.ModTime().Equal(entry.ModTime)
+}
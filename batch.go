@@ -0,0 +1,122 @@
+package otfreader
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/nsip/otf-reader/internal/util"
+	"github.com/pkg/errors"
+)
+
+//
+// identifies one reading of an input file, the batch id is
+// added to the meta-data of every record from the file, along
+// with the hash of the file content
+//
+type fileBatch struct {
+	id   string
+	hash string // sha-256 of the file, hex encoded
+}
+
+//
+// hashes the file content and gives it a new batch id,
+// leaving the file positioned back at the start.
+// the names and content of any other files read along with
+// it (the entity files of a oneroster bundle folder) are part
+// of the hash, so the batch changes if any of them changes.
+//
+func newFileBatch(f *os.File, others ...string) (fileBatch, error) {
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return fileBatch{}, errors.Wrap(err, "unable to hash file")
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return fileBatch{}, errors.Wrap(err, "unable to rewind file")
+	}
+	for _, p := range others {
+		h.Write([]byte("\x00" + filepath.Base(p) + "\x00"))
+		if err := hashFile(h, p); err != nil && !os.IsNotExist(err) {
+			return fileBatch{}, errors.Wrap(err, "unable to hash "+p)
+		}
+	}
+
+	return fileBatch{id: util.GenerateID(), hash: hex.EncodeToString(h.Sum(nil))}, nil
+}
+
+func hashFile(w io.Writer, fileName string) error {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(w, f)
+	return err
+}
+
+//
+// a message id that is the same every time the same content
+// is read from the same path, so that brokers that drop
+// duplicates (jetstream) do not store records twice if a file
+// is read again. name tells apart the messages of the batch,
+// eg. the record number.
+//
+func (fb fileBatch) messageID(fileName string, name string) string {
+	sum := sha256.Sum256([]byte(fileName + "\x00" + fb.hash + "\x00" + name))
+	return hex.EncodeToString(sum[:16])
+}
+
+//
+// the last batch read from each file path, so that
+// later events for the file can refer to its records
+//
+type batchIndex struct {
+	mu    sync.Mutex
+	files map[string]fileBatch
+}
+
+func newBatchIndex() *batchIndex {
+	return &batchIndex{files: map[string]fileBatch{}}
+}
+
+func (bi *batchIndex) set(path string, batch fileBatch) {
+	bi.mu.Lock()
+	defer bi.mu.Unlock()
+	bi.files[path] = batch
+}
+
+func (bi *batchIndex) get(path string) (fileBatch, bool) {
+	bi.mu.Lock()
+	defer bi.mu.Unlock()
+	batch, ok := bi.files[path]
+	return batch, ok
+}
+
+//
+// forgets a removed file, returning its last batch
+//
+func (bi *batchIndex) remove(path string) (fileBatch, bool) {
+	bi.mu.Lock()
+	defer bi.mu.Unlock()
+	batch, ok := bi.files[path]
+	delete(bi.files, path)
+	return batch, ok
+}
+
+//
+// moves the batch of a renamed or moved file to its new path
+//
+func (bi *batchIndex) move(oldPath, newPath string) (fileBatch, bool) {
+	bi.mu.Lock()
+	defer bi.mu.Unlock()
+	batch, ok := bi.files[oldPath]
+	if ok {
+		delete(bi.files, oldPath)
+		bi.files[newPath] = batch
+	}
+	return batch, ok
+}
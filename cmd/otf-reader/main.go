@@ -17,14 +17,58 @@ func main() {
 		readerName    = fs.String("name", "", "name for this reader")
 		readerID      = fs.String("id", "", "id for this reader, leave blank to auto-generate a unique id")
 		providerName  = fs.String("provider", "", "name of product or system supplying the data")
-		inputFormat   = fs.String("inputFormat", "csv", "format of input data, one of csv|json")
+		inputFormat   = fs.String("inputFormat", "csv", "format of input data, one of csv|json|ndjson|jsonl|xml|xlsx|oneroster")
+		encoding      = fs.String("encoding", "auto", "character encoding of input files, one of auto|utf-8|utf-16le|utf-16be|windows-1252|iso-8859-1")
+		xmlPath       = fs.String("xmlPath", "", "for xml input, path of the repeating element to publish as records, eg. /StudentPersonals/StudentPersonal")
+		selector      = fs.String("selector", "", "for json/ndjson input, jq style path selecting the records to publish from nested data, eg. .[].allocations[]")
+		carryFields   = fs.String("carryFields", "", "comma separated list of parent fields copied into each selected record, as path or dest=src, eg. school,test.name=name")
+		csvDelimiter  = fs.String("csvDelimiter", ",", "for csv input, field delimiter; a single character, or \\t (or tab) for tab-separated files")
+		csvQuote      = fs.String("csvQuote", "\"", "for csv input, quote character")
+		csvComment    = fs.String("csvComment", "", "for csv input, lines starting with this prefix are ignored")
+		csvSkipLines  = fs.Int("csvSkipLines", 0, "for csv input, number of lines to skip before the header row")
+		csvHeader     = fs.String("csvHeader", "", "for csv input without a header row, comma separated list of column names")
+		csvInferTypes = fs.Bool("csvInferTypes", false, "for csv/xlsx input, publish values that look like numbers or booleans as json numbers/booleans, and empty values as null")
+		csvTypes      = fs.String("csvTypes", "", "for csv/xlsx input, comma separated list of column:type (string|number|integer|boolean), eg. score:number,passed:boolean")
+		xlsxSheet     = fs.String("xlsxSheet", "", "for xlsx input, name or 1-based index of the sheet to read, first sheet if blank")
+		xlsxHeaderRow = fs.Int("xlsxHeaderRow", 1, "for xlsx input, 1-based row number of the header row")
 		alignMethod   = fs.String("alignMethod", "", "method to align input data to NLPs must be one of prescribed|mapped|inferred")
 		levelMethod   = fs.String("levelMethod", "", "method to apply common scaling this data, one of prescribed|mapped-scale|rules")
 		genCapability = fs.String("capability", "", "General Capability for assessment results; Literacy or Numeracy")
 		natsPort      = fs.Int("natsPort", 4222, "connection port for nats broker")
 		natsHost      = fs.String("natsHost", "localhost", "hostname/ip of nats broker")
 		natsCluster   = fs.String("natsCluster", "test-cluster", "cluster id for nats broker")
+		publisher     = fs.String("publisher", "stan", "how to publish messages, one of stan (nats streaming)|jetstream|file|http")
+		jsStream      = fs.String("jsStream", "OTF", "jetstream stream to publish to, created if it does not exist")
+		jsSubjects    = fs.String("jsSubjects", "", "comma separated list of subjects for a new jetstream stream, defaults to the topic and all topics below it")
+		jsMaxPending  = fs.Int("jsMaxPending", 4000, "maximum number of jetstream messages waiting for acknowledgement")
+		outFile       = fs.String("outFile", "-", "for the file publisher, file to write messages to as ndjson, - for stdout")
+		outRotateMB   = fs.Int("outRotateMB", 0, "for the file publisher, start a new output file when it reaches this size in MB, 0 to never rotate")
+		outTopics     = fs.Bool("outTopics", false, "for the file publisher, write each message as {\"topic\":...,\"message\":...} so the topic it would have been published to is shown")
+		httpURL       = fs.String("httpURL", "", "for the http publisher, url that messages are posted to")
+		httpHeaders   = fs.String("httpHeaders", "", "for the http publisher, comma separated list of Name:Value headers sent with every post")
+		httpToken     = fs.String("httpToken", "", "for the http publisher, bearer token sent in the Authorization header")
+		httpBatchSize = fs.Int("httpBatchSize", 1, "for the http publisher, number of messages posted together as a json array")
+		httpConc      = fs.Int("httpConcurrency", 4, "for the http publisher, maximum number of posts in progress at once")
+		httpRetries   = fs.Int("httpRetries", 5, "for the http publisher, number of times a post that times out or gets a server error is retried")
+		httpTimeout   = fs.String("httpTimeout", "30s", "for the http publisher, time allowed for each post")
 		topic         = fs.String("topic", "", "nats topic name to publish parsed data items to")
+		topicRoutes   = fs.String("topicRoutes", "", "comma separated list of templates picking the topic for each message from its values, eg. otf.ingest.{{meta.capability}}.{{original.school.sector}}")
+		topicDefault  = fs.String("topicDefault", "", "topic for messages that match none of the topicRoutes, defaults to topic")
+		dlTopic       = fs.String("deadLetterTopic", "", "topic that records which cannot be read or published are sent to, with the error and their position in the file")
+		dlFolder      = fs.String("deadLetterFolder", "", "if no deadLetterTopic, folder where records which cannot be read or published are saved")
+		controlMsgs   = fs.Bool("controlMessages", false, "publish fileStart and fileComplete control messages before and after the records of each file")
+		controlTopic  = fs.String("controlTopic", "", "topic for control messages, defaults to topic")
+		fileEvents    = fs.String("fileEventTopic", "", "topic that events are published to when a watched file is removed, renamed or moved")
+		stateFolder   = fs.String("stateFolder", "", "folder for the ledger of processed files, so the same content is not published twice, even after a restart")
+		backlog       = fs.String("backlog", "none", "files already in the watched folder to publish at startup, one of all|unprocessed|none")
+		delta         = fs.Bool("delta", false, "only publish records added or changed since a file was last read, needs stateFolder")
+		deltaKey      = fs.String("deltaKey", "", "for delta, comma separated fields that identify a record (eg. sourcedId), if not given records are matched on their whole content")
+		deltaDeletes  = fs.Bool("deltaDeletes", false, "for delta, publish a deleted message for each record that has gone from a file")
+		disposition   = fs.String("disposition", "none", "what happens to files once read, one of move (to processedFolder, or failedFolder if they fail)|delete (if published, failed files are moved)|none")
+		processedDir  = fs.String("processedFolder", "processed", "folder that published files are moved to, relative paths are within the watched folder")
+		failedDir     = fs.String("failedFolder", "failed", "folder that files which fail are moved to (with an .error.txt file), relative paths are within the watched folder")
+		datePartition = fs.Bool("datePartitions", false, "move files to a yyyy/mm/dd folder within the processed and failed folders")
+		gzipProcessed = fs.Bool("gzipProcessed", false, "gzip files when they are moved to the processed folder")
 		_             = fs.String("config", "", "config file (optional), json format.")
 		folder        = fs.String("folder", ".", "folder to watch for data files")
 		fileSuffix    = fs.String("suffix", "", "filter files to read by file extension, eg. .csv or .myapp (actual data handling will be determined by input format flag)")
@@ -32,7 +76,13 @@ func main() {
 		recursive     = fs.Bool("recursive", true, "watch folders recursively")
 		dotfiles      = fs.Bool("dotfiles", false, "watch dot files")
 		ignore        = fs.String("ignore", "", "comma separated list of paths to ignore")
+		settle        = fs.String("settle", "stable", "how to tell a file has been completely written before reading it, one of stable|marker|none")
+		settleIvals   = fs.Int("settleIntervals", 2, "for settle stable, number of poll intervals a file's size and modification time must be unchanged")
+		readyMarkers  = fs.String("readyMarkers", ".done,.ready", "for settle marker, comma separated suffixes of the marker file that shows a file is ready, eg. results.csv.done")
+		tempSuffixes  = fs.String("tempSuffixes", "part,tmp", "comma separated suffixes of temp files that are never read, eg. while being uploaded")
 		concurrFiles  = fs.Int("concurrFiles", 10, "pool size for concurrent file processing")
+		fileInFlight  = fs.Int("fileInFlight", 1000, "maximum number of messages from a file waiting to be acknowledged")
+		maxInFlight   = fs.Int("maxInFlight", 10000, "maximum number of messages across all files waiting to be acknowledged")
 	)
 
 	ff.Parse(fs, os.Args[1:],
@@ -46,6 +96,12 @@ func main() {
 		otfr.ID(*readerID),
 		otfr.ProviderName(*providerName),
 		otfr.InputFormat(*inputFormat),
+		otfr.Encoding(*encoding),
+		otfr.XMLRecordPath(*xmlPath),
+		otfr.RecordSelector(*selector, *carryFields),
+		otfr.CSVDialect(*csvDelimiter, *csvQuote, *csvComment, *csvSkipLines, *csvHeader),
+		otfr.CSVTypes(*csvInferTypes, *csvTypes),
+		otfr.XLSXSheet(*xlsxSheet, *xlsxHeaderRow),
 		otfr.LevelMethod(*levelMethod),
 		otfr.AlignMethod(*alignMethod),
 		otfr.Capability(*genCapability),
@@ -53,13 +109,27 @@ func main() {
 		otfr.NatsHostName(*natsHost),
 		otfr.NatsClusterName(*natsCluster),
 		otfr.TopicName(*topic),
+		otfr.TopicRoutes(*topicRoutes, *topicDefault),
+		otfr.DeadLetter(*dlTopic, *dlFolder),
+		otfr.ControlMessages(*controlMsgs, *controlTopic),
+		otfr.FileEvents(*fileEvents),
+		otfr.StateFolder(*stateFolder),
+		otfr.Backlog(*backlog),
+		otfr.Delta(*delta, *deltaKey, *deltaDeletes),
+		otfr.Disposition(*disposition, *processedDir, *failedDir, *datePartition, *gzipProcessed),
+		otfr.PublisherType(*publisher),
+		otfr.JetStream(*jsStream, *jsSubjects, *jsMaxPending),
+		otfr.FileSink(*outFile, *outRotateMB, *outTopics),
+		otfr.HTTPSink(*httpURL, *httpHeaders, *httpToken, *httpBatchSize, *httpConc, *httpRetries, *httpTimeout),
 		otfr.Watcher(*folder, *fileSuffix, *interval, *recursive, *dotfiles, *ignore),
+		otfr.Settle(*settle, *settleIvals, *readyMarkers, *tempSuffixes),
 		otfr.ConcurrentFiles(*concurrFiles),
+		otfr.MaxInFlight(*fileInFlight, *maxInFlight),
 	}
 
 	rdr, err := otfr.New(opts...)
 	if err != nil {
-		fmt.Printf("\nCannot create otf-reader:\n%s\n\n", err)
+		fmt.Fprintf(os.Stderr, "\nCannot create otf-reader:\n%s\n\n", err)
 		return
 	}
 
@@ -67,22 +137,21 @@ func main() {
 
 	// signal handler for shutdown
 	closed := make(chan struct{})
-	c := make(chan os.Signal)
+	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Kill, os.Interrupt)
 	go func() {
 		<-c
-		fmt.Println("\nreader shutting down")
+		fmt.Fprintln(os.Stderr, "\nreader shutting down")
 		rdr.Close()
-		fmt.Println("otf-reader closed")
+		fmt.Fprintln(os.Stderr, "otf-reader closed")
 		close(closed)
 	}()
 
-	// [need to invoke pre-process for existing files/maybe]
-
-	// start the filewatcher
+	// start the filewatcher, publishing any
+	// existing files first (see backlog flag)
 	launchErr := rdr.StartWatcher()
 	if launchErr != nil {
-		fmt.Printf("\n  Error: Unable to start file watcher: %s\n\n", launchErr)
+		fmt.Fprintf(os.Stderr, "\n  Error: Unable to start file watcher: %s\n\n", launchErr)
 		close(closed)
 	}
 
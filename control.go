@@ -0,0 +1,77 @@
+package otfreader
+
+import (
+	"encoding/json"
+	"log"
+)
+
+//
+// control message types, published before the first
+// and after the last record of each file
+//
+const (
+	controlFileStart    = "fileStart"
+	controlFileComplete = "fileComplete"
+)
+
+//
+// the outcome of publishing a file, sent with fileComplete
+//
+type fileCounts struct {
+	Published int64 `json:"published"`
+	Failed    int64 `json:"failed"`
+	Rejected  int64 `json:"rejected"`
+	Unchanged int64 `json:"unchanged,omitempty"` // delta mode
+	Deleted   int64 `json:"deleted,omitempty"`   // delta mode
+}
+
+//
+// a control message, the meta block matches the meta-data
+// of the file's records (including its batchID), so downstream
+// stages can tell when a batch of records starts and is complete
+//
+type controlMessage struct {
+	Control string          `json:"control"`
+	Meta    json.RawMessage `json:"meta"`
+	Counts  *fileCounts     `json:"counts,omitempty"`
+	Error   string          `json:"error,omitempty"`
+}
+
+//
+// publishes a control message for the file to the control
+// topic (or the reader topic), if control messages are enabled.
+// counts and fileErr are only given for fileComplete.
+//
+func (rdr *OtfReader) publishControl(control, fileName string, batch fileBatch, counts *fileCounts, fileErr error) {
+
+	if !rdr.controlMsgs {
+		return
+	}
+
+	cm := controlMessage{
+		Control: control,
+		Meta:    rdr.metaBytes(fileName, batch, batch.messageID(fileName, control)),
+		Counts:  counts,
+	}
+	if fileErr != nil {
+		cm.Error = fileErr.Error()
+	}
+	msg, err := json.Marshal(cm)
+	if err != nil {
+		log.Printf("Error: cannot create %s message for %s: %v\n", control, fileName, err)
+		return
+	}
+
+	topic := rdr.controlTopic
+	if topic == "" {
+		topic = rdr.publishTopic
+	}
+	_, err = rdr.publisher.Publish(topic, msg, func(msgID string, err error) {
+		if err != nil {
+			log.Printf("Error: %s message %s for %s not published: %v\n", control, msgID, fileName, err)
+		}
+	})
+	if err != nil {
+		log.Printf("Error: %s message for %s not published: %v\n", control, fileName, err)
+	}
+}
@@ -0,0 +1,265 @@
+package otfreader
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+)
+
+//
+// settings describing the layout of csv input files
+//
+type csvDialect struct {
+	delimiter rune
+	quote     rune
+	comment   string
+	skipLines int
+	header    []string
+}
+
+//
+// the standard comma-separated, double-quoted dialect
+//
+var defaultCSVDialect = csvDialect{delimiter: ',', quote: '"'}
+
+//
+// reads a csv file as a stream, one row at a time, using the
+// first row as the header (unless the dialect supplies one).
+// each row is converted to a json object keyed by the header
+// names and handed to the record handler, so memory use does
+// not grow with the size of the file.
+//
+func readCSV(r io.Reader, fileName string, dialect csvDialect, types *columnTypes, handler recordHandler) error {
+
+	br := bufio.NewReader(r)
+
+	// skip any banner lines above the header
+	for i := 0; i < dialect.skipLines; i++ {
+		if _, err := br.ReadString('\n'); err != nil {
+			if err == io.EOF {
+				return errors.New("csv file is empty")
+			}
+			return errors.Wrap(err, "unable to skip csv lines")
+		}
+	}
+
+	cr := &csvReader{r: br, dialect: dialect, line: dialect.skipLines}
+
+	var header []string
+	if len(dialect.header) > 0 {
+		header = append(header, dialect.header...)
+	} else {
+		row, err := cr.Read()
+		if err == io.EOF {
+			return errors.New("csv file is empty")
+		}
+		if err != nil {
+			return errors.Wrap(err, "unable to read csv header")
+		}
+		header = csvHeader(row, fileName)
+	}
+
+	var buf bytes.Buffer
+	for {
+		row, err := cr.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if rerr, ok := err.(*csvRowError); ok {
+			if err := rejectRecord(handler, []byte(rerr.raw), fmt.Sprintf("line %d", rerr.line), rerr.err); err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return errors.Wrap(err, "unable to read csv row")
+		}
+		header = extendHeader(header, len(row))
+		if err := handler(rowJSON(&buf, header, row, types)); err != nil {
+			return err
+		}
+	}
+}
+
+//
+// builds a json object from a row of values keyed by the header
+// names, the header must be at least as long as the row.
+// values are strings unless column types are given.
+// the buffer is reused between rows, a copy of the json is returned.
+//
+func rowJSON(buf *bytes.Buffer, header []string, row []string, types *columnTypes) []byte {
+
+	buf.Reset()
+	buf.WriteByte('{')
+	for i, v := range row {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		writeJSONString(buf, header[i])
+		buf.WriteByte(':')
+		types.writeValue(buf, header[i], v)
+	}
+	buf.WriteByte('}')
+
+	return append([]byte(nil), buf.Bytes()...)
+}
+
+//
+// copies the header row, naming any empty columns
+// as column_n (n being the zero-based column index)
+//
+func csvHeader(row []string, fileName string) []string {
+	header := make([]string, len(row))
+	for i, h := range row {
+		if h == "" {
+			h = fmt.Sprintf("column_%d", i)
+			log.Printf("Warning: %s - column[%d] has empty header, named %s\n", fileName, i, h)
+		}
+		header[i] = h
+	}
+	return header
+}
+
+//
+// names any extra columns in rows longer than the header
+// as column_n (n being the zero-based column index)
+//
+func extendHeader(header []string, n int) []string {
+	for len(header) < n {
+		header = append(header, fmt.Sprintf("column_%d", len(header)))
+	}
+	return header
+}
+
+func writeJSONString(buf *bytes.Buffer, s string) {
+	b, _ := json.Marshal(s) // marshalling a string cannot fail
+	buf.Write(b)
+}
+
+//
+// minimal csv parser supporting the configurable parts of the
+// dialect (the standard library reader only allows double-quotes).
+// empty lines and comment lines are skipped, quoted fields
+// may contain delimiters, newlines and doubled quote characters.
+//
+type csvReader struct {
+	r       *bufio.Reader
+	dialect csvDialect
+	line    int
+}
+
+//
+// a row that cannot be parsed, with the text read for it
+//
+type csvRowError struct {
+	line int
+	raw  string
+	err  error
+}
+
+func (e *csvRowError) Error() string {
+	return fmt.Sprintf("line %d: %v", e.line, e.err)
+}
+
+//
+// returns the fields of the next row, or io.EOF
+//
+func (cr *csvReader) Read() ([]string, error) {
+
+	var line string
+	for {
+		l, err := cr.readLine()
+		if err != nil {
+			return nil, err
+		}
+		if l == "" {
+			continue
+		}
+		if cr.dialect.comment != "" && strings.HasPrefix(l, cr.dialect.comment) {
+			continue
+		}
+		line = l
+		break
+	}
+
+	startLine := cr.line
+	var raw strings.Builder
+	raw.WriteString(line)
+	fields := []string{}
+	var field strings.Builder
+	for {
+		if line != "" && cr.startsWith(line, cr.dialect.quote) {
+			// quoted field
+			line = line[utf8.RuneLen(cr.dialect.quote):]
+			for {
+				i := strings.IndexRune(line, cr.dialect.quote)
+				if i < 0 {
+					// field continues onto the next line
+					field.WriteString(line)
+					field.WriteByte('\n')
+					next, err := cr.readLine()
+					if err == io.EOF {
+						return nil, &csvRowError{line: startLine, raw: raw.String(), err: errors.New("quoted field is not closed")}
+					}
+					if err != nil {
+						return nil, err
+					}
+					raw.WriteByte('\n')
+					raw.WriteString(next)
+					line = next
+					continue
+				}
+				field.WriteString(line[:i])
+				line = line[i+utf8.RuneLen(cr.dialect.quote):]
+				if cr.startsWith(line, cr.dialect.quote) {
+					// doubled quote is a literal quote
+					field.WriteRune(cr.dialect.quote)
+					line = line[utf8.RuneLen(cr.dialect.quote):]
+					continue
+				}
+				break
+			}
+		}
+
+		// unquoted field, or any text after a closing quote
+		i := strings.IndexRune(line, cr.dialect.delimiter)
+		if i < 0 {
+			field.WriteString(line)
+			fields = append(fields, field.String())
+			return fields, nil
+		}
+		field.WriteString(line[:i])
+		fields = append(fields, field.String())
+		field.Reset()
+		line = line[i+utf8.RuneLen(cr.dialect.delimiter):]
+	}
+}
+
+//
+// reads the next physical line without its line ending
+//
+func (cr *csvReader) readLine() (string, error) {
+	l, err := cr.r.ReadString('\n')
+	if err == io.EOF && l == "" {
+		return "", io.EOF
+	}
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	cr.line++
+	l = strings.TrimSuffix(l, "\n")
+	l = strings.TrimSuffix(l, "\r")
+	return l, nil
+}
+
+func (cr *csvReader) startsWith(s string, r rune) bool {
+	c, _ := utf8.DecodeRuneInString(s)
+	return c == r
+}
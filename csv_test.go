@@ -0,0 +1,110 @@
+package otfreader
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadCSVDialects(t *testing.T) {
+
+	cases := []struct {
+		name     string
+		dialect  csvDialect
+		input    string
+		records  []string // json of each record
+		rejected []string // offsets of rows that could not be parsed
+	}{
+		{
+			name:    "tab delimiter",
+			dialect: csvDialect{delimiter: '\t', quote: '"'},
+			input:   "id\tname\n1\tAda Lovelace\n",
+			records: []string{`{"id":"1","name":"Ada Lovelace"}`},
+		},
+		{
+			name:    "semicolon delimiter",
+			dialect: csvDialect{delimiter: ';', quote: '"'},
+			input:   "id;score\n1;2,5\n",
+			records: []string{`{"id":"1","score":"2,5"}`},
+		},
+		{
+			name:    "single quotes",
+			dialect: csvDialect{delimiter: ',', quote: '\''},
+			input:   "id,name\n1,'Smith, Jo'\n",
+			records: []string{`{"id":"1","name":"Smith, Jo"}`},
+		},
+		{
+			name:    "doubled quotes",
+			dialect: defaultCSVDialect,
+			input:   "id,comment\n1,\"she said \"\"hi\"\"\"\n",
+			records: []string{`{"id":"1","comment":"she said \"hi\""}`},
+		},
+		{
+			name:    "quoted delimiter",
+			dialect: defaultCSVDialect,
+			input:   "id,amount,unit\n1,\"1,000\",g\n",
+			records: []string{`{"id":"1","amount":"1,000","unit":"g"}`},
+		},
+		{
+			name:    "multi-line field",
+			dialect: defaultCSVDialect,
+			input:   "id,address\r\n1,\"1 Main St\r\nSpringfield\"\r\n2,none\r\n",
+			records: []string{
+				`{"id":"1","address":"1 Main St\nSpringfield"}`,
+				`{"id":"2","address":"none"}`,
+			},
+		},
+		{
+			name:    "trailing empty field",
+			dialect: defaultCSVDialect,
+			input:   "id,name,note\n1,Ada,\n",
+			records: []string{`{"id":"1","name":"Ada","note":""}`},
+		},
+		{
+			name:    "skip lines and comments",
+			dialect: csvDialect{delimiter: ',', quote: '"', comment: "#", skipLines: 2},
+			input:   "Assessment export\nrun 2020-07-16\nid,name\n# a comment\n1,Ada\n\n#,another\n2,Grace\n",
+			records: []string{
+				`{"id":"1","name":"Ada"}`,
+				`{"id":"2","name":"Grace"}`,
+			},
+		},
+		{
+			name:     "unclosed quote at end of file",
+			dialect:  defaultCSVDialect,
+			input:    "id,name\n1,Ada\n2,\"Grace\n3,Alan\n",
+			records:  []string{`{"id":"1","name":"Ada"}`},
+			rejected: []string{"line 3"},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			records := []string{}
+			rejected := []string{}
+			handler := func(m []byte, meta ...metaField) error {
+				for _, mf := range meta {
+					if br, ok := mf.value.(*badRecord); ok {
+						rejected = append(rejected, br.offset)
+						return nil
+					}
+				}
+				records = append(records, string(m))
+				return nil
+			}
+
+			if err := readCSV(strings.NewReader(c.input), "test.csv", c.dialect, nil, handler); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(records, c.records) {
+				t.Errorf("records are\n%v\nexpected\n%v", records, c.records)
+			}
+			if c.rejected == nil {
+				c.rejected = []string{}
+			}
+			if !reflect.DeepEqual(rejected, c.rejected) {
+				t.Errorf("rejected %v, expected %v", rejected, c.rejected)
+			}
+		})
+	}
+}
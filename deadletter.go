@@ -0,0 +1,212 @@
+package otfreader
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+//
+// passed as a meta field value by the format readers for
+// records that cannot be parsed, so that publishFile sends
+// them to the dead-letter destination instead of publishing
+// them (any other meta fields are kept as context)
+//
+type badRecord struct {
+	offset string
+	err    error
+}
+
+//
+// hands a record that could not be parsed to the handler, offset
+// describes where it was found in the file, eg. "line 12"
+//
+func rejectRecord(handler recordHandler, raw []byte, offset string, err error) error {
+	return handler(raw, metaField{value: &badRecord{offset: offset, err: err}})
+}
+
+//
+// a record that could not be parsed or published, along with
+// enough context to find and fix it at the source
+//
+type deadLetter struct {
+	Error          string                 `json:"error"`
+	Stage          string                 `json:"stage"` // parse or publish
+	SourceFileName string                 `json:"sourceFileName"`
+	Offset         string                 `json:"offset,omitempty"`
+	Topic          string                 `json:"topic,omitempty"`
+	Meta           map[string]interface{} `json:"meta,omitempty"`
+	Raw            string                 `json:"raw"`
+	ReaderName     string                 `json:"readerName"`
+	ReaderID       string                 `json:"readerID"`
+	TimestampUTC   string                 `json:"timestampUTC"`
+}
+
+//
+// serialises writes to dead-letter files
+//
+var deadLetterMu sync.Mutex
+
+//
+// reports the record, and sends it to the dead-letter topic or
+// folder if one is configured. failures here are only logged,
+// so that the rest of the file is still processed.
+//
+func (rdr *OtfReader) deadLetter(fileName, stage, topic string, raw []byte, offset string, cause error, meta []metaField) {
+
+	where := ""
+	if offset != "" {
+		where = " (" + offset + ")"
+	}
+	log.Printf("Warning: %s error in %s%s, record skipped: %v\n", stage, fileName, where, cause)
+
+	if rdr.deadLetterTopic == "" && rdr.deadLetterDir == "" {
+		return
+	}
+
+	dl := deadLetter{
+		Error:          cause.Error(),
+		Stage:          stage,
+		SourceFileName: fileName,
+		Offset:         offset,
+		Topic:          topic,
+		Raw:            string(raw),
+		ReaderName:     rdr.name,
+		ReaderID:       rdr.ID,
+		TimestampUTC:   time.Now().UTC().Format(time.RFC3339),
+	}
+	for _, mf := range meta {
+		if mf.name == "" {
+			continue
+		}
+		if dl.Meta == nil {
+			dl.Meta = map[string]interface{}{}
+		}
+		dl.Meta[mf.name] = mf.value
+	}
+	msg, err := json.Marshal(dl)
+	if err != nil {
+		log.Printf("Error: cannot create dead letter for %s: %v\n", fileName, err)
+		return
+	}
+
+	if rdr.deadLetterTopic != "" {
+		rdr.deadLetters.add(queuedDeadLetter{fileName: fileName, msg: msg}, rdr.publishDeadLetters)
+		return
+	}
+	if err := writeDeadLetter(rdr.deadLetterDir, fileName, msg); err != nil {
+		log.Printf("Error: dead letter from %s not saved: %v\n", fileName, err)
+	}
+}
+
+//
+// dead letters waiting to be published to the dead-letter topic.
+// they are published from a goroutine of their own, never by the
+// caller, as publish failures are reported from within the
+// publisher's ack handling, which may be holding the publisher
+// slot that publishing the dead letter would wait for.
+// the queue has no limit, so adding to it never blocks.
+//
+type deadLetterQueue struct {
+	mu      sync.Mutex
+	ready   *sync.Cond // signalled when letters are added
+	letters []queuedDeadLetter
+	started bool
+	pending sync.WaitGroup
+}
+
+type queuedDeadLetter struct {
+	fileName string
+	msg      []byte
+}
+
+func newDeadLetterQueue() *deadLetterQueue {
+	q := &deadLetterQueue{}
+	q.ready = sync.NewCond(&q.mu)
+	return q
+}
+
+//
+// queues the letter, starting the publishing
+// goroutine for the first one
+//
+func (q *deadLetterQueue) add(dl queuedDeadLetter, publish func()) {
+	q.pending.Add(1)
+	q.mu.Lock()
+	q.letters = append(q.letters, dl)
+	start := !q.started
+	q.started = true
+	q.ready.Signal()
+	q.mu.Unlock()
+	if start {
+		go publish()
+	}
+}
+
+//
+// the next letter, blocking until there is one
+//
+func (q *deadLetterQueue) next() queuedDeadLetter {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for len(q.letters) == 0 {
+		q.ready.Wait()
+	}
+	dl := q.letters[0]
+	q.letters = q.letters[1:]
+	return dl
+}
+
+//
+// blocks until every queued letter has been handed to the publisher
+//
+func (q *deadLetterQueue) wait() {
+	q.pending.Wait()
+}
+
+//
+// publishes queued dead letters for the life of the reader
+//
+func (rdr *OtfReader) publishDeadLetters() {
+	for {
+		dl := rdr.deadLetters.next()
+		_, err := rdr.publisher.Publish(rdr.deadLetterTopic, dl.msg, func(msgID string, err error) {
+			if err != nil {
+				log.Printf("Error: dead letter %s from %s not published: %v\n", msgID, dl.fileName, err)
+			}
+		})
+		if err != nil {
+			log.Printf("Error: dead letter from %s not saved: %v\n", dl.fileName, err)
+		}
+		rdr.deadLetters.pending.Done()
+	}
+}
+
+//
+// appends the dead letter to a file in the folder named after
+// the input file, eg. results.csv.deadletter.ndjson
+//
+func writeDeadLetter(folder, fileName string, msg []byte) error {
+
+	deadLetterMu.Lock()
+	defer deadLetterMu.Unlock()
+
+	if err := os.MkdirAll(folder, 0755); err != nil {
+		return errors.Wrap(err, "unable to create dead letter folder")
+	}
+	p := filepath.Join(folder, filepath.Base(fileName)+".deadletter.ndjson")
+	f, err := os.OpenFile(p, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return errors.Wrap(err, "unable to open dead letter file")
+	}
+	if _, err := f.Write(append(msg, '\n')); err != nil {
+		f.Close()
+		return errors.Wrap(err, "unable to write dead letter file")
+	}
+	return f.Close()
+}
@@ -0,0 +1,153 @@
+package otfreader
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/tidwall/gjson"
+)
+
+//
+// the fingerprint of a record published from a file, saved
+// in the ledger so that when the file is read again only the
+// records that are new or have changed are published
+//
+type recordPrint struct {
+	Topic string          `json:"topic"`
+	Key   json.RawMessage `json:"key,omitempty"` // values of the key fields
+	Hash  string          `json:"hash"`
+}
+
+//
+// compares the records of a file with those from the last
+// time it was read. records are matched on the delta key
+// fields if given (eg. "sourcedId" or "school.id,student.id"),
+// otherwise on their content, in which case a changed record
+// is seen as a new one (and the old one as deleted).
+//
+// methods can be called on a nil tracker (when delta mode is
+// off), which treats every record as added.
+//
+type deltaTracker struct {
+	keyPaths  []string
+	prev      map[string]recordPrint
+	seen      map[string]recordPrint
+	copies    map[string]int
+	unchanged int
+}
+
+//
+// change values added to the meta-data of records in delta mode
+//
+const (
+	deltaAdded   = "added"
+	deltaChanged = "changed"
+	deltaDeleted = "deleted"
+)
+
+//
+// starts tracking the records of the file, against the
+// fingerprints in the ledger unless forced to publish
+// every record
+//
+func (rdr *OtfReader) newDeltaTracker(fileName string, force bool) *deltaTracker {
+
+	if !rdr.delta {
+		return nil
+	}
+	dt := &deltaTracker{
+		keyPaths: rdr.deltaKeys,
+		seen:     map[string]recordPrint{},
+		copies:   map[string]int{},
+	}
+	if force {
+		return dt
+	}
+	prev, err := rdr.ledger.getRecords(fileName)
+	if err != nil {
+		log.Printf("Warning: unable to read record fingerprints for %s, all records will be published: %v\n", fileName, err)
+	}
+	dt.prev = prev
+	return dt
+}
+
+//
+// records the record as seen, and returns whether it was
+// added or changed since the file was last read along with
+// its hash, false if it is unchanged and so does not need
+// to be published
+//
+func (dt *deltaTracker) change(topic string, m []byte, meta []metaField) (string, string, bool) {
+
+	if dt == nil {
+		return "", "", true
+	}
+
+	sum := sha256.Sum256(m)
+	rp := recordPrint{Topic: topic, Hash: hex.EncodeToString(sum[:16])}
+
+	// records are only compared with others from the same
+	// topic, archive member and sheet
+	scope := []string{topic}
+	for _, mf := range meta {
+		if mf.name == "archiveMember" || mf.name == "sourceSheet" {
+			scope = append(scope, fmt.Sprint(mf.value))
+		}
+	}
+	key := strings.Join(scope, "|") + "|"
+
+	if len(dt.keyPaths) > 0 {
+		// as a json object, eg. {"sourcedId":"123"}
+		values := map[string]interface{}{}
+		for _, p := range dt.keyPaths {
+			values[p] = gjson.GetBytes(m, p).Value()
+		}
+		rp.Key, _ = json.Marshal(values)
+		key += string(rp.Key)
+	} else {
+		// identical records are told apart by
+		// how many times they have been seen
+		key += rp.Hash
+		dt.copies[key]++
+		key += fmt.Sprintf("#%d", dt.copies[key])
+	}
+
+	dt.seen[key] = rp
+	old, found := dt.prev[key]
+	switch {
+	case !found:
+		return deltaAdded, rp.Hash, true
+	case old.Hash != rp.Hash:
+		return deltaChanged, rp.Hash, true
+	}
+	dt.unchanged++
+	return "", rp.Hash, false
+}
+
+//
+// records from the last time the file was read
+// that are no longer in it, in key order
+//
+func (dt *deltaTracker) deleted() []recordPrint {
+
+	if dt == nil {
+		return nil
+	}
+	keys := []string{}
+	for key := range dt.prev {
+		if _, ok := dt.seen[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	gone := make([]recordPrint, len(keys))
+	for i, key := range keys {
+		gone[i] = dt.prev[key]
+	}
+	return gone
+}
@@ -0,0 +1,223 @@
+package otfreader
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestDeltaTracker(t *testing.T) {
+
+	type record struct {
+		topic  string
+		json   string
+		member string // archiveMember
+	}
+	ingest := func(records ...string) []record {
+		rs := []record{}
+		for _, r := range records {
+			rs = append(rs, record{topic: "otf.ingest", json: r})
+		}
+		return rs
+	}
+
+	cases := []struct {
+		name    string
+		keys    []string
+		first   []record
+		second  []record
+		changes []string // for each record of the second read, "" if unchanged
+		deleted []string // keys (or hashes) of the deleted records
+	}{
+		{
+			name:    "keyed",
+			keys:    []string{"id"},
+			first:   ingest(`{"id":1,"v":"a"}`, `{"id":2,"v":"b"}`, `{"id":3,"v":"c"}`),
+			second:  ingest(`{"id":2,"v":"b"}`, `{"id":1,"v":"z"}`, `{"id":4,"v":"d"}`),
+			changes: []string{"", deltaChanged, deltaAdded},
+			deleted: []string{`{"id":3}`},
+		},
+		{
+			name:    "compound key",
+			keys:    []string{"school.id", "student"},
+			first:   ingest(`{"school":{"id":1},"student":"a","v":1}`, `{"school":{"id":2},"student":"a","v":1}`),
+			second:  ingest(`{"school":{"id":1},"student":"a","v":2}`, `{"school":{"id":2},"student":"a","v":1}`),
+			changes: []string{deltaChanged, ""},
+			deleted: []string{},
+		},
+		{
+			name:    "missing key field",
+			keys:    []string{"id"},
+			first:   ingest(`{"v":1}`),
+			second:  ingest(`{"v":2}`),
+			changes: []string{deltaChanged},
+			deleted: []string{},
+		},
+		{
+			name:    "content only",
+			first:   ingest(`{"v":"a"}`, `{"v":"b"}`),
+			second:  ingest(`{"v":"b"}`, `{"v":"c"}`),
+			changes: []string{"", deltaAdded},
+			deleted: []string{`{"v":"a"}`},
+		},
+		{
+			name:    "identical records counted",
+			first:   ingest(`{"v":"a"}`, `{"v":"a"}`),
+			second:  ingest(`{"v":"a"}`, `{"v":"a"}`, `{"v":"a"}`),
+			changes: []string{"", "", deltaAdded},
+			deleted: []string{},
+		},
+		{
+			name:    "fewer identical records",
+			first:   ingest(`{"v":"a"}`, `{"v":"a"}`),
+			second:  ingest(`{"v":"a"}`),
+			changes: []string{""},
+			deleted: []string{`{"v":"a"}`},
+		},
+		{
+			name:    "scoped by topic",
+			keys:    []string{"id"},
+			first:   []record{{topic: "otf.ingest.users", json: `{"id":1}`}},
+			second:  []record{{topic: "otf.ingest.orgs", json: `{"id":1}`}},
+			changes: []string{deltaAdded},
+			deleted: []string{`{"id":1}`},
+		},
+		{
+			name:    "scoped by archive member",
+			keys:    []string{"id"},
+			first:   []record{{topic: "otf.ingest", json: `{"id":1}`, member: "a.csv"}},
+			second:  []record{{topic: "otf.ingest", json: `{"id":1}`, member: "a.csv"}, {topic: "otf.ingest", json: `{"id":1}`, member: "b.csv"}},
+			changes: []string{"", deltaAdded},
+			deleted: []string{},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			read := func(dt *deltaTracker, records []record) []string {
+				changes := []string{}
+				for _, r := range records {
+					meta := []metaField{}
+					if r.member != "" {
+						meta = append(meta, metaField{name: "archiveMember", value: r.member})
+					}
+					change, hash, publish := dt.change(r.topic, []byte(r.json), meta)
+					if publish != (change != "") || len(hash) != 32 {
+						t.Errorf("%s gives change %q, hash %q, publish %v", r.json, change, hash, publish)
+					}
+					changes = append(changes, change)
+				}
+				return changes
+			}
+
+			first := &deltaTracker{keyPaths: c.keys, seen: map[string]recordPrint{}, copies: map[string]int{}}
+			read(first, c.first)
+			second := &deltaTracker{keyPaths: c.keys, prev: first.seen, seen: map[string]recordPrint{}, copies: map[string]int{}}
+			if changes := read(second, c.second); !reflect.DeepEqual(changes, c.changes) {
+				t.Errorf("changes are %q, expected %q", changes, c.changes)
+			}
+
+			// deleted records are identified by their key,
+			// or without a key by the hash of their content
+			deleted := []string{}
+			for _, rp := range second.deleted() {
+				if rp.Key != nil {
+					deleted = append(deleted, string(rp.Key))
+					continue
+				}
+				for _, r := range c.first {
+					if contentHash(r.json) == rp.Hash {
+						deleted = append(deleted, r.json)
+						break
+					}
+				}
+			}
+			if !reflect.DeepEqual(deleted, c.deleted) {
+				t.Errorf("deleted %v, expected %v", deleted, c.deleted)
+			}
+			unchanged := 0
+			for _, ch := range c.changes {
+				if ch == "" {
+					unchanged++
+				}
+			}
+			if second.unchanged != unchanged {
+				t.Errorf("%d unchanged, expected %d", second.unchanged, unchanged)
+			}
+		})
+	}
+}
+
+//
+// the hash a tracker gives the record's content
+//
+func contentHash(record string) string {
+	dt := &deltaTracker{seen: map[string]recordPrint{}, copies: map[string]int{}}
+	_, hash, _ := dt.change("", []byte(record), nil)
+	return hash
+}
+
+func TestNilDeltaTracker(t *testing.T) {
+	var dt *deltaTracker
+	if change, _, publish := dt.change("otf.ingest", []byte(`{}`), nil); change != "" || !publish {
+		t.Errorf("change %q, publish %v", change, publish)
+	}
+	if deleted := dt.deleted(); deleted != nil {
+		t.Errorf("deleted %v", deleted)
+	}
+}
+
+func TestPublishFileDelta(t *testing.T) {
+
+	p := &memPublisher{}
+	rdr := newTestReader(t, p)
+	if err := Delta(true, "id", true)(rdr); err != nil {
+		t.Fatal(err)
+	}
+	rdr.ledger, _ = openTestLedger(t)
+	defer rdr.ledger.close()
+
+	// the changes published by each read, sorted
+	read := func(path string, force bool) []string {
+		p.mu.Lock()
+		p.msgs = nil
+		p.mu.Unlock()
+		rdr.publishFile(path, force)
+		changes := []string{}
+		for _, m := range p.topic("otf.ingest") {
+			var msg struct {
+				Meta     map[string]interface{} `json:"meta"`
+				Original map[string]interface{} `json:"original"`
+			}
+			if err := json.Unmarshal(m, &msg); err != nil {
+				t.Fatal(err)
+			}
+			changes = append(changes, msg.Meta["deltaChange"].(string)+" "+msg.Original["id"].(string))
+		}
+		sort.Strings(changes)
+		return changes
+	}
+
+	path := writeTestFile(t, "results.csv", testCSV)
+	if changes := read(path, false); !reflect.DeepEqual(changes, []string{"added 1", "added 2", "added 3"}) {
+		t.Errorf("first read published %v", changes)
+	}
+
+	ioutil.WriteFile(path, []byte("id,name\n1,Ada\n3,Grace Hopper\n4,Alan\n"), 0644)
+	expected := []string{"added 4", "changed 3", "deleted 2"}
+	if changes := read(path, false); !reflect.DeepEqual(changes, expected) {
+		t.Errorf("second read published %v, expected %v", changes, expected)
+	}
+	counts := completeCounts(t, p)
+	if counts.Published != 3 || counts.Unchanged != 1 || counts.Deleted != 1 {
+		t.Errorf("counts are %+v", counts)
+	}
+
+	// forced reads publish every record
+	expected = []string{"added 1", "added 3", "added 4"}
+	if changes := read(path, true); !reflect.DeepEqual(changes, expected) {
+		t.Errorf("forced read published %v, expected %v", changes, expected)
+	}
+}
@@ -0,0 +1,254 @@
+package otfreader
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+//
+// what happens to a file once it has been read (see Disposition).
+// files that were published are moved to the processed folder (or
+// deleted), and files that failed are moved to the failed folder
+// along with an .error.txt file describing what went wrong.
+// failures to move a file are only logged, and leave it in place.
+//
+func (rdr *OtfReader) dispose(fileName string, batch fileBatch, cause error) {
+
+	if rdr.disposition == "none" || !rdr.disposable(fileName) {
+		return
+	}
+
+	// the watcher reports the file as removed once it has gone,
+	// which is not passed on as a file event (see disposed)
+	rdr.disposed.add(fileName)
+
+	if cause == nil && rdr.disposition == "delete" {
+		if err := os.Remove(fileName); err != nil {
+			rdr.disposed.remove(fileName)
+			log.Printf("Error: unable to delete %s: %v\n", fileName, err)
+			return
+		}
+		rdr.batches.remove(fileName)
+		fmt.Fprintln(rdr.out, "deleted", fileName)
+		return
+	}
+
+	dir, compress := rdr.processedDir, rdr.gzipProcessed
+	if cause != nil {
+		dir, compress = rdr.failedDir, false
+	}
+	dest := rdr.disposePath(dir, fileName)
+	if compress && isArchive(fileName) {
+		// already compressed
+		compress = false
+	}
+	if compress {
+		dest += ".gz"
+	}
+	dest = uniquePath(dest)
+
+	// the error file is written first, so that it is
+	// there by the time the failed file appears
+	if cause != nil {
+		if err := rdr.writeErrorFile(dest+".error.txt", fileName, batch, cause); err != nil {
+			log.Printf("Warning: unable to write error file for %s: %v\n", fileName, err)
+		}
+	}
+
+	if err := moveFile(fileName, dest, compress); err != nil {
+		rdr.disposed.remove(fileName)
+		log.Printf("Error: unable to move %s to %s: %v\n", fileName, dest, err)
+		return
+	}
+	rdr.batches.remove(fileName)
+	fmt.Fprintf(rdr.out, "moved %s to %s\n", fileName, dest)
+}
+
+//
+// resolves the processed and failed folders (relative paths
+// are within the watch folder), creating them, and has the
+// watcher ignore them so that files moved there are not
+// read again
+//
+func (rdr *OtfReader) setupDisposition() error {
+
+	if rdr.disposition == "none" {
+		return nil
+	}
+	dirs := []*string{&rdr.failedDir}
+	if rdr.disposition == "move" {
+		dirs = append(dirs, &rdr.processedDir)
+	}
+	for _, dir := range dirs {
+		if !filepath.IsAbs(*dir) {
+			*dir = filepath.Join(rdr.watchFolder, *dir)
+		}
+		abs, err := filepath.Abs(*dir)
+		if err != nil {
+			return errors.Wrap(err, "unable to find folder "+*dir)
+		}
+		*dir = abs
+		if err := os.MkdirAll(abs, 0755); err != nil {
+			return errors.Wrap(err, "unable to create folder "+abs)
+		}
+		if rdr.watcher != nil {
+			if err := rdr.watcher.Ignore(abs); err != nil {
+				return errors.Wrap(err, "unable to ignore folder "+abs)
+			}
+		}
+	}
+	return nil
+}
+
+//
+// false for the files of a oneroster bundle folder, which
+// are read together when the manifest is read, so are left
+// in place (zipped bundles are moved like any other file)
+//
+func (rdr *OtfReader) disposable(fileName string) bool {
+	if rdr.inputFormat != "oneroster" {
+		return true
+	}
+	return strings.EqualFold(filepath.Ext(fileName), ".zip")
+}
+
+//
+// the path of the file in the processed or failed folder,
+// keeping its path within the watch folder, below a
+// yyyy/mm/dd folder if date partitions are used
+//
+func (rdr *OtfReader) disposePath(dir string, fileName string) string {
+
+	rel := filepath.Base(fileName)
+	if wf, err := filepath.Abs(rdr.watchFolder); err == nil {
+		if r, err := filepath.Rel(wf, fileName); err == nil && !strings.HasPrefix(r, "..") {
+			rel = r
+		}
+	}
+	if rdr.datePartition {
+		dir = filepath.Join(dir, filepath.FromSlash(time.Now().Format("2006/01/02")))
+	}
+	return filepath.Join(dir, rel)
+}
+
+func (rdr *OtfReader) writeErrorFile(path string, fileName string, batch fileBatch, cause error) error {
+
+	text := fmt.Sprintf("file: %s\nerror: %v\nsourceFileHash: %s\nbatchID: %s\nreaderName: %s\nreaderID: %s\ntimestampUTC: %s\n",
+		fileName, cause, batch.hash, batch.id, rdr.name, rdr.ID,
+		time.Now().UTC().Format(time.RFC3339))
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, []byte(text), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+//
+// moves the file, compressing it with gzip if asked.
+// the file only appears at dest once it is complete: a
+// rename is atomic, and otherwise (when compressing, or
+// the folders are on different filesystems) the file is
+// copied to a temp file next to dest, which is then renamed.
+//
+func moveFile(src string, dest string, compress bool) error {
+
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return errors.Wrap(err, "unable to create folder")
+	}
+	if !compress {
+		if err := os.Rename(src, dest); err == nil {
+			return nil
+		}
+	}
+
+	tmp := dest + ".tmp"
+	if err := copyFile(src, tmp, compress); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, dest); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Remove(src)
+}
+
+func copyFile(src string, dest string, compress bool) error {
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+	var w io.Writer = out
+	var gz *gzip.Writer
+	if compress {
+		gz = gzip.NewWriter(out)
+		gz.Name = filepath.Base(src)
+		w = gz
+	}
+	if _, err := io.Copy(w, in); err != nil {
+		out.Close()
+		return err
+	}
+	if gz != nil {
+		if err := gz.Close(); err != nil {
+			out.Close()
+			return err
+		}
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+//
+// adds -1, -2... to the file name (before any extensions)
+// while a file already exists with that name
+//
+func uniquePath(path string) string {
+
+	if !fileExists(path) {
+		return path
+	}
+	dir, name := filepath.Split(path)
+	ext := ""
+	if i := strings.Index(name, "."); i > 0 {
+		name, ext = name[:i], name[i:]
+	}
+	p := path
+	for n := 1; fileExists(p); n++ {
+		p = filepath.Join(dir, fmt.Sprintf("%s-%d%s", name, n, ext))
+	}
+	return p
+}
+
+func isArchive(fileName string) bool {
+	ext := strings.TrimPrefix(strings.ToLower(filepath.Ext(fileName)), ".")
+	for _, s := range archiveSuffixes {
+		if ext == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,148 @@
+package otfreader
+
+import (
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDispose(t *testing.T) {
+
+	today := filepath.FromSlash(time.Now().Format("2006/01/02"))
+
+	cases := []struct {
+		name     string
+		mode     string
+		dates    bool
+		gzip     bool
+		fail     bool
+		existing bool   // a file with the same name is already there
+		dest     string // where the file ends up, relative to the watch folder
+	}{
+		{name: "none", mode: "none", dest: "in/results.csv"},
+		{name: "none and failed", mode: "none", fail: true, dest: "in/results.csv"},
+		{name: "move", mode: "move", dest: "processed/in/results.csv"},
+		{name: "move by date", mode: "move", dates: true, dest: filepath.Join("processed", today, "in/results.csv")},
+		{name: "move and gzip", mode: "move", gzip: true, dest: "processed/in/results.csv.gz"},
+		{name: "move to a new name", mode: "move", existing: true, dest: "processed/in/results-1.csv"},
+		{name: "move failed", mode: "move", fail: true, dest: "failed/in/results.csv"},
+		{name: "failed not gzipped", mode: "move", gzip: true, fail: true, dest: "failed/in/results.csv"},
+		{name: "delete", mode: "delete"},
+		{name: "delete failed", mode: "delete", fail: true, dest: "failed/in/results.csv"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			p := &memPublisher{publishErr: func(topic string, msg []byte) error {
+				if c.fail && topic == "otf.ingest" {
+					return errors.New("not connected")
+				}
+				return nil
+			}}
+			rdr := newTestReader(t, p)
+			files := map[string]string{"in/results.csv": testCSV}
+			if c.existing {
+				files["processed/in/results.csv"] = ""
+			}
+			watch := writeTestFolder(t, files)
+			rdr.watchFolder = watch
+			if err := Disposition(c.mode, "", "", c.dates, c.gzip)(rdr); err != nil {
+				t.Fatal(err)
+			}
+			if err := rdr.setupDisposition(); err != nil {
+				t.Fatal(err)
+			}
+
+			path := filepath.Join(watch, "in/results.csv")
+			if err := publishTestFile(t, rdr, path); (err != nil) != c.fail {
+				t.Fatalf("publishFile returned %v", err)
+			}
+
+			if c.dest != filepath.FromSlash("in/results.csv") && fileExists(path) {
+				t.Error("file left in place")
+			}
+			if c.dest == "" {
+				if fileExists(path) {
+					t.Error("file not deleted")
+				}
+				return
+			}
+			dest := filepath.Join(watch, filepath.FromSlash(c.dest))
+			if content := readTestFile(t, dest, c.gzip && !c.fail); content != testCSV {
+				t.Errorf("%s holds %q", c.dest, content)
+			}
+
+			errorFile := dest + ".error.txt"
+			if c.fail && c.mode != "none" {
+				text := readTestFile(t, errorFile, false)
+				if !strings.Contains(text, "error: 3 records") || !strings.Contains(text, "file: "+path) {
+					t.Errorf("error file is\n%s", text)
+				}
+			} else if fileExists(errorFile) {
+				t.Error("error file written")
+			}
+		})
+	}
+}
+
+func readTestFile(t *testing.T, path string, gzipped bool) string {
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var r io.Reader = f
+	if gzipped {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if gz.Name != filepath.Base(strings.TrimSuffix(path, ".gz")) {
+			t.Errorf("gzip name is %s", gz.Name)
+		}
+		r = gz
+	}
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestUniquePath(t *testing.T) {
+
+	dir := writeTestFolder(t, map[string]string{"results.csv.gz": "", "results-1.csv.gz": "", "notes": ""})
+	cases := map[string]string{
+		"results.csv.gz": "results-2.csv.gz",
+		"results.csv":    "results.csv",
+		"notes":          "notes-1",
+	}
+	for name, expected := range cases {
+		if p := uniquePath(filepath.Join(dir, name)); p != filepath.Join(dir, expected) {
+			t.Errorf("%s gives %s, expected %s", name, filepath.Base(p), expected)
+		}
+	}
+}
+
+func TestIsArchive(t *testing.T) {
+
+	cases := map[string]bool{
+		"results.zip":    true,
+		"results.CSV.GZ": true,
+		"bundle.tgz":     true,
+		"bundle.tar":     true,
+		"results.csv":    false,
+		"results":        false,
+	}
+	for name, archive := range cases {
+		if isArchive(name) != archive {
+			t.Errorf("%s archive is %v, expected %v", name, !archive, archive)
+		}
+	}
+}
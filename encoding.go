@@ -0,0 +1,124 @@
+package otfreader
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/unicode"
+	"golang.org/x/text/transform"
+)
+
+var (
+	bomUTF8    = []byte{0xEF, 0xBB, 0xBF}
+	bomUTF16LE = []byte{0xFF, 0xFE}
+	bomUTF16BE = []byte{0xFE, 0xFF}
+)
+
+//
+// how much of a file is inspected when auto-detecting encoding
+//
+const sniffLen = 4096
+
+//
+// normalises the name of a supported character encoding,
+// returns an error if the encoding is not supported
+//
+func encodingName(enc string) (string, error) {
+	switch strings.ToLower(strings.TrimSpace(enc)) {
+	case "", "auto":
+		return "auto", nil
+	case "utf-8", "utf8":
+		return "utf-8", nil
+	case "utf-16le", "utf16le":
+		return "utf-16le", nil
+	case "utf-16be", "utf16be":
+		return "utf-16be", nil
+	case "windows-1252", "cp1252":
+		return "windows-1252", nil
+	case "iso-8859-1", "latin1", "latin-1":
+		return "iso-8859-1", nil
+	}
+	return "", errors.New("encoding " + enc + " not supported (must be one of auto|utf-8|utf-16le|utf-16be|windows-1252|iso-8859-1)")
+}
+
+//
+// wraps the input so that it is read as utf-8 with any
+// byte order mark removed, transcoding from the given
+// encoding if necessary.
+//
+// in auto mode the encoding is taken from a byte order mark
+// if there is one, otherwise files that are not valid utf-8
+// are treated as windows-1252 (the usual Excel export encoding).
+//
+func decodeInput(r io.Reader, enc string) (io.Reader, error) {
+
+	br := bufio.NewReaderSize(r, sniffLen)
+	head, err := br.Peek(sniffLen)
+	if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+		return nil, errors.Wrap(err, "unable to read start of file")
+	}
+
+	if enc == "auto" {
+		enc = detectEncoding(head)
+	}
+
+	var e encoding.Encoding
+	switch enc {
+	case "utf-8":
+		if bytes.HasPrefix(head, bomUTF8) {
+			br.Discard(len(bomUTF8))
+		}
+		return br, nil
+	case "utf-16le":
+		e = unicode.UTF16(unicode.LittleEndian, unicode.UseBOM)
+	case "utf-16be":
+		e = unicode.UTF16(unicode.BigEndian, unicode.UseBOM)
+	case "windows-1252":
+		e = charmap.Windows1252
+	case "iso-8859-1":
+		e = charmap.ISO8859_1
+	default:
+		return nil, errors.New("unsupported encoding " + enc)
+	}
+
+	return transform.NewReader(br, e.NewDecoder()), nil
+}
+
+//
+// picks an encoding from the first bytes of a file
+//
+func detectEncoding(head []byte) string {
+
+	switch {
+	case bytes.HasPrefix(head, bomUTF8):
+		return "utf-8"
+	case bytes.HasPrefix(head, bomUTF16LE):
+		return "utf-16le"
+	case bytes.HasPrefix(head, bomUTF16BE):
+		return "utf-16be"
+	}
+
+	// utf-16 without a bom, ascii text has every other byte zero
+	if len(head) >= 2 && head[0] != 0 && head[1] == 0 {
+		return "utf-16le"
+	}
+	if len(head) >= 2 && head[0] == 0 && head[1] != 0 {
+		return "utf-16be"
+	}
+
+	// ignore a multi-byte character cut off at the end of the sample
+	valid := head
+	for i := 0; i < utf8.UTFMax-1 && len(valid) > 0 && !utf8.Valid(valid); i++ {
+		valid = valid[:len(valid)-1]
+	}
+	if utf8.Valid(valid) {
+		return "utf-8"
+	}
+	return "windows-1252"
+}
@@ -0,0 +1,105 @@
+package otfreader
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/radovskyb/watcher"
+)
+
+//
+// a file event published when a watched file is removed,
+// renamed or moved, so that downstream stores can retract
+// or flag the records that came from it (found by batchID)
+//
+type fileEvent struct {
+	Event          string `json:"event"` // fileRemoved, fileRenamed or fileMoved
+	Path           string `json:"path"`
+	OldPath        string `json:"oldPath,omitempty"`
+	SourceFileHash string `json:"sourceFileHash,omitempty"`
+	BatchID        string `json:"batchID,omitempty"`
+	ProviderName   string `json:"providerName"`
+	ReaderName     string `json:"readerName"`
+	ReaderID       string `json:"readerID"`
+	TimestampUTC   string `json:"timestampUTC"`
+}
+
+var fileEventNames = map[watcher.Op]string{
+	watcher.Remove: "fileRemoved",
+	watcher.Rename: "fileRenamed",
+	watcher.Move:   "fileMoved",
+}
+
+//
+// publishes a remove, rename or move watcher event to the file
+// event topic (if set), with the hash and batch id of the file
+// when it was last read. the file's batch (and ledger entry)
+// follows it to its new path, so that later events refer to
+// the same records; a removed file is dropped from the ledger
+// so it is published again if copied back in.
+//
+func (rdr *OtfReader) publishFileEvent(event watcher.Event) {
+
+	name, ok := fileEventNames[event.Op]
+	if !ok {
+		return
+	}
+
+	fe := fileEvent{
+		Event:        name,
+		Path:         event.Path,
+		ProviderName: rdr.providerName,
+		ReaderName:   rdr.name,
+		ReaderID:     rdr.ID,
+		TimestampUTC: time.Now().UTC().Format(time.RFC3339),
+	}
+	// files read before the reader was restarted
+	// are found in the ledger, if there is one
+	oldPath := event.Path
+	if event.Op != watcher.Remove {
+		oldPath = event.OldPath
+		fe.OldPath = event.OldPath
+	}
+	entry, inLedger, err := rdr.ledger.get(oldPath)
+	if err != nil {
+		log.Printf("Warning: unable to check ledger for %s: %v\n", oldPath, err)
+	}
+
+	var batch fileBatch
+	var known bool
+	if event.Op == watcher.Remove {
+		batch, known = rdr.batches.remove(event.Path)
+		err = rdr.ledger.remove(event.Path)
+	} else {
+		batch, known = rdr.batches.move(event.OldPath, event.Path)
+		err = rdr.ledger.move(event.OldPath, event.Path)
+	}
+	if err != nil {
+		log.Printf("Warning: unable to update ledger for %s: %v\n", oldPath, err)
+	}
+	if !known && inLedger {
+		batch, known = fileBatch{id: entry.BatchID, hash: entry.SHA256}, true
+	}
+	if known {
+		fe.SourceFileHash = batch.hash
+		fe.BatchID = batch.id
+	}
+
+	if rdr.fileEventTopic == "" {
+		return
+	}
+	msg, err := json.Marshal(fe)
+	if err != nil {
+		log.Printf("Error: cannot create %s event for %s: %v\n", name, event.Path, err)
+		return
+	}
+	_, err = rdr.publisher.Publish(rdr.fileEventTopic, msg, func(msgID string, err error) {
+		if err != nil {
+			log.Printf("Error: %s event %s for %s not published: %v\n", name, msgID, event.Path, err)
+		}
+	})
+	if err != nil {
+		log.Printf("Error: %s event for %s not published: %v\n", name, event.Path, err)
+	}
+}
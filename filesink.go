@@ -0,0 +1,182 @@
+package otfreader
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nsip/otf-reader/internal/util"
+	"github.com/pkg/errors"
+)
+
+//
+// writes otf messages as newline-delimited json to stdout or
+// to a file, instead of publishing them to a broker; for dry runs,
+// golden-file tests, or for sites that ship files onward later.
+// each line is exactly the otf message that would have been
+// published (compacted onto a single line), or if withTopic is
+// set an object with the topic and the message, eg.
+// {"topic":"otf.ingest","message":{...}}
+//
+type fileSinkPublisher struct {
+	mu       sync.Mutex
+	path     string // empty for stdout
+	rotateAt int64  // rotate once the file reaches this size, 0 for never
+	file     *os.File
+	w        io.Writer
+	written  int64
+	lineBuf  bytes.Buffer
+	topics   bool
+}
+
+//
+// a line of output when topics are included
+//
+type topicLine struct {
+	Topic   string          `json:"topic"`
+	Message json.RawMessage `json:"message"`
+}
+
+//
+// creates a sink writing to the given file path, or to stdout
+// if the path is empty or "-". rotateMB > 0 starts a new file
+// once the current one reaches that size in megabytes.
+//
+func newFileSinkPublisher(path string, rotateMB int, withTopic bool) (*fileSinkPublisher, error) {
+
+	fs := &fileSinkPublisher{topics: withTopic}
+	if path == "" || path == "-" {
+		fs.w = os.Stdout
+		return fs, nil
+	}
+
+	fs.path = path
+	if rotateMB > 0 {
+		fs.rotateAt = int64(rotateMB) * 1024 * 1024
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, errors.Wrap(err, "unable to create output folder")
+	}
+	if err := fs.open(); err != nil {
+		return nil, err
+	}
+	return fs, nil
+}
+
+//
+// opens (appends to) the output file
+//
+func (fs *fileSinkPublisher) open() error {
+	f, err := os.OpenFile(fs.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return errors.Wrap(err, "unable to open output file")
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	fs.file = f
+	fs.w = f
+	fs.written = fi.Size()
+	return nil
+}
+
+//
+// moves the full output file aside, named with the
+// current time (eg. otf-20200716T101500.123.ndjson),
+// and starts a new one
+//
+func (fs *fileSinkPublisher) rotate() error {
+	if err := fs.closeFile(); err != nil {
+		return err
+	}
+	ext := filepath.Ext(fs.path)
+	stamp := time.Now().UTC().Format("20060102T150405.000")
+	rotated := fmt.Sprintf("%s-%s%s", strings.TrimSuffix(fs.path, ext), stamp, ext)
+	for n := 1; fileExists(rotated); n++ {
+		rotated = fmt.Sprintf("%s-%s-%d%s", strings.TrimSuffix(fs.path, ext), stamp, n, ext)
+	}
+	if err := os.Rename(fs.path, rotated); err != nil {
+		return errors.Wrap(err, "unable to rotate output file")
+	}
+	return fs.open()
+}
+
+func (fs *fileSinkPublisher) Publish(topic string, msg []byte, ack AckHandler) (string, error) {
+
+	id := util.GenerateID()
+	if fs.topics {
+		line, err := json.Marshal(topicLine{Topic: topic, Message: msg})
+		if err != nil {
+			return id, errors.Wrap(err, "invalid otf message")
+		}
+		msg = line
+	}
+	if err := fs.writeLine(msg); err != nil {
+		return id, err
+	}
+	if ack != nil {
+		ack(id, nil)
+	}
+	return id, nil
+}
+
+func (fs *fileSinkPublisher) writeLine(msg []byte) error {
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if fs.w == nil {
+		return errors.New("output file is closed")
+	}
+
+	fs.lineBuf.Reset()
+	if err := json.Compact(&fs.lineBuf, msg); err != nil {
+		return errors.Wrap(err, "invalid otf message")
+	}
+	fs.lineBuf.WriteByte('\n')
+
+	if fs.rotateAt > 0 && fs.written > 0 && fs.written+int64(fs.lineBuf.Len()) > fs.rotateAt {
+		if err := fs.rotate(); err != nil {
+			return err
+		}
+	}
+
+	// lines are written unbuffered, so output is complete
+	// as soon as each message has been acknowledged
+	n, err := fs.w.Write(fs.lineBuf.Bytes())
+	fs.written += int64(n)
+	return err
+}
+
+func (fs *fileSinkPublisher) Flush() error {
+	return nil
+}
+
+func (fs *fileSinkPublisher) Close() error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	return fs.closeFile()
+}
+
+func (fs *fileSinkPublisher) closeFile() error {
+	var err error
+	if fs.file != nil {
+		err = fs.file.Close()
+		fs.file = nil
+	}
+	fs.w = nil
+	return err
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
@@ -3,11 +3,11 @@ module github.com/nsip/otf-reader
 go 1.14
 
 require (
-	github.com/burntsushi/toml v0.3.1 // indirect
-	github.com/cdutwhu/n3-util v0.0.0-20200612113609-e7a936321cf1
+	github.com/360EntSecGroup-Skylar/excelize/v2 v2.3.0
 	github.com/golang/protobuf v1.4.2 // indirect
 	github.com/nats-io/nats-server/v2 v2.1.7 // indirect
 	github.com/nats-io/nats-streaming-server v0.17.0 // indirect
+	github.com/nats-io/nats.go v1.13.0
 	github.com/nats-io/nuid v1.0.1
 	github.com/nats-io/stan.go v0.6.0
 	github.com/peterbourgon/ff v1.7.0
@@ -15,5 +15,8 @@ require (
 	github.com/radovskyb/watcher v1.0.7
 	github.com/speps/go-hashids v2.0.0+incompatible
 	github.com/stretchr/testify v1.6.1 // indirect
+	github.com/tidwall/gjson v1.6.0
 	github.com/tidwall/sjson v1.1.1
+	go.etcd.io/bbolt v1.3.5
+	golang.org/x/text v0.3.3
 )
@@ -0,0 +1,278 @@
+package otfreader
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/nsip/otf-reader/internal/util"
+	"github.com/pkg/errors"
+	"github.com/tidwall/gjson"
+)
+
+//
+// first wait before retrying a failed post, doubled
+// on each attempt up to the maximum wait
+//
+const (
+	httpRetryWait    = 500 * time.Millisecond
+	httpMaxRetryWait = 30 * time.Second
+)
+
+//
+// how long a partly filled batch waits for more
+// messages before it is sent anyway
+//
+const httpBatchLinger = time.Second
+
+//
+// posts otf messages to an http ingest endpoint, for sites that
+// don't run nats. each message is the body of a POST request,
+// or with a batch size > 1 messages for the same topic are
+// sent together as a json array.
+// the topic is sent in the Otf-Topic header, and for single
+// messages the otf messageID in the Otf-Message-Id header.
+// posts that time out or get a 5xx (or 429) response are retried
+// with exponential backoff, other responses are treated as failed.
+//
+type httpPublisher struct {
+	client    *http.Client
+	url       string
+	header    http.Header
+	batchSize int
+	retries   int
+	slots     chan struct{} // limits concurrent posts
+	mu        sync.Mutex
+	batches   map[string]*httpBatch
+	inFlight  sync.WaitGroup
+}
+
+//
+// messages waiting to be posted together
+//
+type httpBatch struct {
+	msgs  [][]byte
+	ids   []string
+	acks  []AckHandler
+	timer *time.Timer
+}
+
+//
+// creates a publisher posting to the given url with the extra
+// headers (and bearer token, if not empty) on every request.
+// concurrency is the maximum number of posts in progress at once,
+// retries the number of times a post is retried before it fails,
+// and timeout the time allowed for each attempt.
+//
+func newHTTPPublisher(url string, header http.Header, token string, batchSize, concurrency, retries int, timeout time.Duration) (*httpPublisher, error) {
+
+	if url == "" {
+		return nil, errors.New("no url given for http publisher")
+	}
+	if batchSize < 1 {
+		batchSize = 1
+	}
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	h := http.Header{}
+	for k, v := range header {
+		h[k] = append([]string(nil), v...)
+	}
+	if h.Get("Content-Type") == "" {
+		h.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		h.Set("Authorization", "Bearer "+token)
+	}
+
+	return &httpPublisher{
+		client:    &http.Client{Timeout: timeout},
+		url:       url,
+		header:    h,
+		batchSize: batchSize,
+		retries:   retries,
+		slots:     make(chan struct{}, concurrency),
+		batches:   map[string]*httpBatch{},
+	}, nil
+}
+
+//
+// adds the message to the batch for its topic, posting the
+// batch once it is full. blocks while the concurrency limit
+// is reached, so the reader slows to the pace of the endpoint.
+//
+func (hp *httpPublisher) Publish(topic string, msg []byte, ack AckHandler) (string, error) {
+
+	id := gjson.GetBytes(msg, "meta.messageID").String()
+	if id == "" {
+		id = util.GenerateID()
+	}
+
+	hp.inFlight.Add(1)
+	hp.mu.Lock()
+	b, ok := hp.batches[topic]
+	if !ok {
+		b = &httpBatch{}
+		hp.batches[topic] = b
+		if hp.batchSize > 1 {
+			b.timer = time.AfterFunc(httpBatchLinger, func() { hp.sendIdle(topic, b) })
+		}
+	}
+	b.msgs = append(b.msgs, msg)
+	b.ids = append(b.ids, id)
+	b.acks = append(b.acks, ack)
+	full := len(b.msgs) >= hp.batchSize
+	if full {
+		hp.take(topic, b)
+	}
+	hp.mu.Unlock()
+
+	if full {
+		hp.send(topic, b)
+	}
+	return id, nil
+}
+
+//
+// sends a partly filled batch that has waited long enough
+//
+func (hp *httpPublisher) sendIdle(topic string, b *httpBatch) {
+	hp.mu.Lock()
+	if hp.batches[topic] != b {
+		// already sent
+		hp.mu.Unlock()
+		return
+	}
+	hp.take(topic, b)
+	hp.mu.Unlock()
+	hp.send(topic, b)
+}
+
+//
+// removes the batch from those being filled,
+// must be called with the lock held
+//
+func (hp *httpPublisher) take(topic string, b *httpBatch) {
+	delete(hp.batches, topic)
+	if b.timer != nil {
+		b.timer.Stop()
+	}
+}
+
+//
+// posts the batch in the background once a slot is free,
+// then passes the outcome to the ack handler of each message
+//
+func (hp *httpPublisher) send(topic string, b *httpBatch) {
+
+	hp.slots <- struct{}{}
+	go func() {
+		defer func() { <-hp.slots }()
+
+		err := hp.post(topic, b)
+		for i, ack := range b.acks {
+			if ack != nil {
+				ack(b.ids[i], err)
+			}
+			hp.inFlight.Done()
+		}
+	}()
+}
+
+//
+// posts the batch, retrying with exponential backoff
+//
+func (hp *httpPublisher) post(topic string, b *httpBatch) error {
+
+	var body []byte
+	if len(b.msgs) == 1 && hp.batchSize == 1 {
+		body = b.msgs[0]
+	} else {
+		body = append(append([]byte{'['}, bytes.Join(b.msgs, []byte{','})...), ']')
+	}
+
+	wait := httpRetryWait
+	for attempt := 0; ; attempt++ {
+		retry, err := hp.postOnce(topic, body, b.ids)
+		if err == nil {
+			return nil
+		}
+		if !retry || attempt >= hp.retries {
+			return errors.Wrapf(err, "http post to %s failed after %d attempt(s)", hp.url, attempt+1)
+		}
+		time.Sleep(wait)
+		if wait *= 2; wait > httpMaxRetryWait {
+			wait = httpMaxRetryWait
+		}
+	}
+}
+
+//
+// makes a single post, returning an error if it did not
+// succeed and whether it is worth trying again
+//
+func (hp *httpPublisher) postOnce(topic string, body []byte, ids []string) (bool, error) {
+
+	req, err := http.NewRequest(http.MethodPost, hp.url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	for k, v := range hp.header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Otf-Topic", topic)
+	if len(ids) == 1 && hp.batchSize == 1 {
+		req.Header.Set("Otf-Message-Id", ids[0])
+	}
+
+	resp, err := hp.client.Do(req)
+	if err != nil {
+		// timeouts and connection failures
+		return true, err
+	}
+	// drain the body so the connection can be reused
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests:
+		return true, errors.New("server responded " + resp.Status)
+	}
+	return false, errors.New("server responded " + resp.Status)
+}
+
+//
+// sends any partly filled batches, then waits
+// for all posts to succeed or fail
+//
+func (hp *httpPublisher) Flush() error {
+
+	hp.mu.Lock()
+	pending := hp.batches
+	hp.batches = map[string]*httpBatch{}
+	for _, b := range pending {
+		if b.timer != nil {
+			b.timer.Stop()
+		}
+	}
+	hp.mu.Unlock()
+
+	for topic, b := range pending {
+		hp.send(topic, b)
+	}
+	hp.inFlight.Wait()
+	return nil
+}
+
+func (hp *httpPublisher) Close() error {
+	hp.Flush()
+	hp.client.CloseIdleConnections()
+	return nil
+}
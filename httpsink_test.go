@@ -0,0 +1,265 @@
+package otfreader
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+//
+// collects the outcome of each publish
+//
+type ackRecorder struct {
+	mu   sync.Mutex
+	errs map[string]error
+}
+
+func newAckRecorder() *ackRecorder {
+	return &ackRecorder{errs: map[string]error{}}
+}
+
+func (ar *ackRecorder) ack(id string, err error) {
+	ar.mu.Lock()
+	defer ar.mu.Unlock()
+	ar.errs[id] = err
+}
+
+func (ar *ackRecorder) count() (acked int, failed int) {
+	ar.mu.Lock()
+	defer ar.mu.Unlock()
+	for _, err := range ar.errs {
+		if err != nil {
+			failed++
+		} else {
+			acked++
+		}
+	}
+	return acked, failed
+}
+
+func TestHTTPPublisherHeaders(t *testing.T) {
+
+	var got *http.Request
+	var body []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+		body, _ = ioutil.ReadAll(r.Body)
+	}))
+	defer srv.Close()
+
+	header := http.Header{}
+	header.Set("X-School", "42")
+	hp, err := newHTTPPublisher(srv.URL, header, "secret", 1, 1, 0, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ar := newAckRecorder()
+	msg := `{"meta":{"messageID":"m1"},"original":{"id":1}}`
+	id, err := hp.Publish("otf.ingest", []byte(msg), ar.ack)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hp.Close()
+
+	if id != "m1" {
+		t.Errorf("message id is %s, expected m1", id)
+	}
+	if acked, _ := ar.count(); acked != 1 {
+		t.Fatalf("message not acked")
+	}
+	for name, want := range map[string]string{
+		"Authorization":  "Bearer secret",
+		"X-School":       "42",
+		"Content-Type":   "application/json",
+		"Otf-Topic":      "otf.ingest",
+		"Otf-Message-Id": "m1",
+	} {
+		if v := got.Header.Get(name); v != want {
+			t.Errorf("header %s is %q, expected %q", name, v, want)
+		}
+	}
+	if string(body) != msg {
+		t.Errorf("body is %s, expected the message as is", body)
+	}
+}
+
+func TestHTTPPublisherBatch(t *testing.T) {
+
+	var mu sync.Mutex
+	bodies := [][]json.RawMessage{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var batch []json.RawMessage
+		if err := json.NewDecoder(r.Body).Decode(&batch); err != nil {
+			t.Errorf("body is not a json array: %v", err)
+		}
+		mu.Lock()
+		bodies = append(bodies, batch)
+		mu.Unlock()
+	}))
+	defer srv.Close()
+
+	hp, err := newHTTPPublisher(srv.URL, nil, "", 3, 1, 0, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ar := newAckRecorder()
+	for _, msg := range []string{`{"n":1}`, `{"n":2}`, `{"n":3}`, `{"n":4}`} {
+		if _, err := hp.Publish("otf.ingest", []byte(msg), ar.ack); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the last, partly filled, batch is sent by flush
+	hp.Flush()
+
+	if acked, failed := ar.count(); acked != 4 || failed != 0 {
+		t.Errorf("%d acked, %d failed, expected 4 acked", acked, failed)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(bodies) != 2 || len(bodies[0]) != 3 || len(bodies[1]) != 1 {
+		t.Fatalf("posted %v, expected batches of 3 and 1", bodies)
+	}
+	if string(bodies[0][2]) != `{"n":3}` {
+		t.Errorf("third member of batch is %s", bodies[0][2])
+	}
+}
+
+func TestHTTPPublisherRetry(t *testing.T) {
+
+	cases := []struct {
+		name     string
+		statuses []int // response to each attempt, then 200
+		attempts int32
+		failed   bool
+	}{
+		{"5xx and 429 retried", []int{http.StatusServiceUnavailable, http.StatusTooManyRequests}, 3, false},
+		{"4xx not retried", []int{http.StatusBadRequest}, 1, true},
+		{"gives up after retries", []int{500, 500, 500}, 3, true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var attempts int32
+			var last time.Time
+			var waits []time.Duration
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				n := atomic.AddInt32(&attempts, 1)
+				if !last.IsZero() {
+					waits = append(waits, time.Since(last))
+				}
+				last = time.Now()
+				if int(n) <= len(c.statuses) {
+					w.WriteHeader(c.statuses[n-1])
+				}
+			}))
+			defer srv.Close()
+
+			hp, err := newHTTPPublisher(srv.URL, nil, "", 1, 1, 2, time.Second)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ar := newAckRecorder()
+			hp.Publish("otf.ingest", []byte(`{}`), ar.ack)
+			hp.Close()
+
+			if attempts != c.attempts {
+				t.Errorf("%d attempts, expected %d", attempts, c.attempts)
+			}
+			if _, failed := ar.count(); (failed == 1) != c.failed {
+				t.Errorf("failed is %v, expected %v", failed == 1, c.failed)
+			}
+			// each wait is double the one before
+			for i, w := range waits {
+				if min := httpRetryWait << uint(i); w < min {
+					t.Errorf("retry %d after %v, expected at least %v", i+1, w, min)
+				}
+			}
+		})
+	}
+}
+
+func TestHTTPPublisherConcurrency(t *testing.T) {
+
+	var current, peak int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&current, 1)
+		for {
+			p := atomic.LoadInt32(&peak)
+			if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+				break
+			}
+		}
+		time.Sleep(50 * time.Millisecond)
+		atomic.AddInt32(&current, -1)
+	}))
+	defer srv.Close()
+
+	hp, err := newHTTPPublisher(srv.URL, nil, "", 1, 2, 0, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ar := newAckRecorder()
+	for i := 0; i < 10; i++ {
+		hp.Publish("otf.ingest", []byte(`{}`), ar.ack)
+	}
+	hp.Close()
+
+	if peak != 2 {
+		t.Errorf("peak of %d posts at once, expected 2", peak)
+	}
+	if acked, _ := ar.count(); acked != 10 {
+		t.Errorf("%d acked, expected 10", acked)
+	}
+}
+
+func TestHTTPPublisherDeadLetters(t *testing.T) {
+
+	// every record is rejected by the endpoint, so each one
+	// becomes a dead letter published through the same sink,
+	// which only allows one post at a time
+	var mu sync.Mutex
+	posts := map[string]int{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		topic := r.Header.Get("Otf-Topic")
+		mu.Lock()
+		posts[topic]++
+		mu.Unlock()
+		if topic == "otf.ingest" {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer srv.Close()
+
+	hp, err := newHTTPPublisher(srv.URL, nil, "", 1, 1, 0, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rdr, err := New(
+		Name("test"),
+		ID("test"),
+		InputFormat("csv"),
+		TopicName("otf.ingest"),
+		DeadLetter("otf.dead", ""),
+		MessagePublisher(hp),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = publishTestFile(t, rdr, writeTestFile(t, "results.csv", testCSV))
+	if err == nil {
+		t.Error("publishFile succeeded, expected 3 records to fail")
+	}
+	rdr.Close()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if posts["otf.ingest"] != 3 || posts["otf.dead"] != 3 {
+		t.Errorf("posted %v, expected 3 records and 3 dead letters", posts)
+	}
+}
@@ -6,10 +6,10 @@ import (
 	"fmt"
 	"log"
 	"math/big"
-	"os"
 	"regexp"
 	"time"
 
+	"github.com/nats-io/nats.go"
 	"github.com/nats-io/nuid"
 	stan "github.com/nats-io/stan.go"
 	hashids "github.com/speps/go-hashids"
@@ -72,20 +72,16 @@ func ValidateNatsTopic(tName string) (bool, error) {
 }
 
 //
-// creates new conenection to nats streaming server
+// creates new conenection to nats streaming server,
+// lost is called if the connection is lost
 //
-func NewConnection(host, cluster, client string, port int) (stan.Conn, error) {
+func NewConnection(host, cluster, client string, port int, lost stan.ConnectionLostHandler) (stan.Conn, error) {
 
 	// Send PINGs every 10 seconds, and fail after 5 PINGs without any response.
 	sc, err := stan.Connect(cluster, client,
 		stan.NatsURL(fmt.Sprintf("nats://%s:%d", host, port)),
 		stan.Pings(10, 5),
-		stan.SetConnectionLostHandler(func(_ stan.Conn, reason error) {
-			log.Printf("\n\tReader shuting down: Connection to streaming server lost, reason: %v\n\n", reason)
-			// attempt clean shutdown by raising sig int
-			p, _ := os.FindProcess(os.Getpid())
-			p.Signal(os.Interrupt)
-		}))
+		stan.SetConnectionLostHandler(lost))
 	if err != nil {
 		return nil, err
 	}
@@ -93,6 +89,33 @@ func NewConnection(host, cluster, client string, port int) (stan.Conn, error) {
 	return sc, nil
 }
 
+//
+// creates new connection to a nats server, for use with jetstream.
+// the connection keeps trying to reconnect if it is lost, buffering
+// published messages meanwhile, and reports the outage when it is back.
+//
+func NewNatsConnection(host, client string, port int) (*nats.Conn, error) {
+
+	var lost time.Time
+	nc, err := nats.Connect(fmt.Sprintf("nats://%s:%d", host, port),
+		nats.Name(client),
+		nats.MaxReconnects(-1),
+		nats.ReconnectWait(2*time.Second),
+		nats.DisconnectErrHandler(func(_ *nats.Conn, err error) {
+			lost = time.Now()
+			log.Printf("Warning: connection to nats server lost: %v, reconnecting\n", err)
+		}),
+		nats.ReconnectHandler(func(nc *nats.Conn) {
+			log.Printf("Reconnected to nats server (%d reconnect(s) so far), outage lasted %s\n", nc.Reconnects, time.Since(lost).Truncate(time.Millisecond))
+		}),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return nc, nil
+}
+
 //
 // small utility function embedded in major ops
 // to print a performance indicator.
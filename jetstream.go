@@ -0,0 +1,244 @@
+package otfreader
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nsip/otf-reader/internal/util"
+	"github.com/pkg/errors"
+	"github.com/tidwall/gjson"
+)
+
+//
+// publishes to a nats jetstream stream.
+// reader topics are used directly as jetstream subjects, so
+// existing topics such as otf.ingest carry on working, as long
+// as the stream is configured to capture them.
+//
+type jetStreamPublisher struct {
+	nc       *nats.Conn
+	js       nats.JetStreamContext
+	futures  chan jsFuture
+	slots    chan struct{} // limits un-acknowledged messages
+	inFlight sync.WaitGroup
+	mu       sync.Mutex
+	closed   bool
+}
+
+//
+// how long to wait for the server to acknowledge a message,
+// matches the nats streaming default
+//
+const jetStreamAckWait = 30 * time.Second
+
+//
+// a pending publish, waiting for its ack from the server
+//
+type jsFuture struct {
+	id     string
+	future nats.PubAckFuture
+	ack    AckHandler
+	sent   time.Time
+}
+
+//
+// connects to the nats server and makes sure the stream exists,
+// creating it to capture the given subjects if not. fails if the
+// stream does not capture every one of the given topics.
+// maxPending limits the number of un-acknowledged messages,
+// Publish waits for an ack once the limit is reached.
+//
+func newJetStreamPublisher(host, client string, port int, stream string, subjects []string, topics []string, maxPending int) (*jetStreamPublisher, error) {
+
+	nc, err := util.NewNatsConnection(host, client, port)
+	if err != nil {
+		return nil, err
+	}
+
+	// nats fails async publishes (rather than waiting) if it
+	// stalls on its own limit, so that is set above the limit
+	// of the publisher, which waits instead. the nats count
+	// includes the message being published.
+	js, err := nc.JetStream(nats.PublishAsyncMaxPending(maxPending + 1))
+	if err != nil {
+		nc.Close()
+		return nil, errors.Wrap(err, "unable to create jetstream context")
+	}
+
+	info, err := js.StreamInfo(stream)
+	switch {
+	case err == nats.ErrStreamNotFound:
+		if err := checkStreamSubjects(stream, subjects, topics); err != nil {
+			nc.Close()
+			return nil, err
+		}
+		_, err = js.AddStream(&nats.StreamConfig{Name: stream, Subjects: subjects})
+		if err != nil {
+			nc.Close()
+			return nil, errors.Wrap(err, "unable to create jetstream stream "+stream)
+		}
+	case err != nil:
+		nc.Close()
+		return nil, errors.Wrap(err, "unable to get jetstream stream "+stream)
+	default:
+		if err := checkStreamSubjects(stream, info.Config.Subjects, topics); err != nil {
+			nc.Close()
+			return nil, err
+		}
+	}
+
+	jp := &jetStreamPublisher{
+		nc:      nc,
+		js:      js,
+		futures: make(chan jsFuture, maxPending),
+		slots:   make(chan struct{}, maxPending),
+	}
+	go jp.collectAcks()
+
+	return jp, nil
+}
+
+//
+// publishes the message, using the otf messageID from the meta-data
+// as the jetstream Nats-Msg-Id, so that the server drops any
+// duplicates if the same message is published again
+//
+func (jp *jetStreamPublisher) Publish(topic string, msg []byte, ack AckHandler) (string, error) {
+
+	id := gjson.GetBytes(msg, "meta.messageID").String()
+	if id == "" {
+		id = util.GenerateID()
+	}
+
+	jp.slots <- struct{}{}
+
+	// the lock keeps Close from closing futures part way
+	// through, the send never blocks as there is room in
+	// futures for every slot
+	jp.mu.Lock()
+	defer jp.mu.Unlock()
+	if jp.closed {
+		<-jp.slots
+		return id, errors.New("publisher is closed")
+	}
+	f, err := jp.js.PublishMsgAsync(&nats.Msg{Subject: topic, Data: msg}, nats.MsgId(id))
+	if err != nil {
+		<-jp.slots
+		return id, err
+	}
+	jp.inFlight.Add(1)
+	jp.futures <- jsFuture{id: id, future: f, ack: ack, sent: time.Now()}
+
+	return id, nil
+}
+
+//
+// waits on each ack future in turn, passing the outcome on
+// to the ack handler. the ack wait runs from the time each
+// message was published, so while the server is not responding
+// every message times out together rather than one by one.
+//
+func (jp *jetStreamPublisher) collectAcks() {
+	for jf := range jp.futures {
+		var err error
+		timeout := time.NewTimer(time.Until(jf.sent.Add(jetStreamAckWait)))
+		select {
+		case <-jf.future.Ok():
+		case err = <-jf.future.Err():
+		case <-timeout.C:
+			err = nats.ErrTimeout
+		}
+		timeout.Stop()
+		<-jp.slots
+		if jf.ack != nil {
+			jf.ack(jf.id, err)
+		}
+		jp.inFlight.Done()
+	}
+}
+
+func (jp *jetStreamPublisher) Flush() error {
+	jp.inFlight.Wait()
+	return nil
+}
+
+//
+// closes the connection, collectAcks carries on until any
+// messages still waiting have been acknowledged or failed
+//
+func (jp *jetStreamPublisher) Close() error {
+	jp.mu.Lock()
+	if !jp.closed {
+		jp.closed = true
+		close(jp.futures)
+	}
+	jp.mu.Unlock()
+	jp.nc.Close()
+	return nil
+}
+
+//
+// the subjects a new stream should capture, from a comma-separated
+// list, defaulting to the reader topic and all topics below it
+// (eg. otf.ingest and otf.ingest.results), along with any other
+// topics the reader publishes to (eg. otf.deadletter)
+//
+func jetStreamSubjects(subjects string, topic string, others []string) []string {
+	list := []string{}
+	for _, s := range strings.Split(subjects, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			list = append(list, s)
+		}
+	}
+	if len(list) > 0 {
+		return list
+	}
+	list = []string{topic, topic + ".>"}
+	for _, t := range others {
+		if !subjectsMatch(list, t) {
+			list = append(list, t)
+		}
+	}
+	return list
+}
+
+//
+// errors if any of the topics would not be captured by the
+// stream, as publishing to them would fail with no responders
+//
+func checkStreamSubjects(stream string, subjects []string, topics []string) error {
+	for _, t := range topics {
+		if !subjectsMatch(subjects, t) {
+			return errors.Errorf("jetstream stream %s does not capture topic %s (subjects %s)", stream, t, strings.Join(subjects, ","))
+		}
+	}
+	return nil
+}
+
+//
+// true if the topic matches any of the subjects, which can use
+// the nats wildcards * (any one token) and > (all remaining tokens)
+//
+func subjectsMatch(subjects []string, topic string) bool {
+	tt := strings.Split(topic, ".")
+	for _, s := range subjects {
+		st := strings.Split(s, ".")
+		for i, tok := range st {
+			if tok == ">" {
+				if len(tt) > i {
+					return true
+				}
+				break
+			}
+			if i >= len(tt) || (tok != "*" && tok != tt[i]) {
+				break
+			}
+			if i == len(st)-1 && len(tt) == len(st) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,86 @@
+package otfreader
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSubjectsMatch(t *testing.T) {
+
+	cases := []struct {
+		subjects string
+		topic    string
+		match    bool
+	}{
+		{"otf.ingest", "otf.ingest", true},
+		{"otf.ingest", "otf.ingest.results", false},
+		{"otf.ingest.results", "otf.ingest", false},
+		{"otf.ingest", "otf.ingested", false},
+		{"otf.ingest.>", "otf.ingest.results", true},
+		{"otf.ingest.>", "otf.ingest.results.school", true},
+		{"otf.ingest.>", "otf.ingest", false},
+		{"otf.*", "otf.ingest", true},
+		{"otf.*", "otf.ingest.results", false},
+		{"otf.*", "otf", false},
+		{"*.ingest", "otf.ingest", true},
+		{"otf.*.results", "otf.ingest.results", true},
+		{"otf.*.results", "otf.ingest.users", false},
+		{">", "otf.deadletter", true},
+		{"otf.ingest,otf.ingest.>,otf.deadletter", "otf.deadletter", true},
+		{"otf.ingest,otf.ingest.>", "otf.control", false},
+		{"", "otf.ingest", false},
+	}
+
+	for _, c := range cases {
+		subjects := []string{}
+		if c.subjects != "" {
+			subjects = strings.Split(c.subjects, ",")
+		}
+		if match := subjectsMatch(subjects, c.topic); match != c.match {
+			t.Errorf("subjects %s match %s is %v, expected %v", c.subjects, c.topic, match, c.match)
+		}
+	}
+}
+
+func TestJetStreamSubjects(t *testing.T) {
+
+	others := []string{"otf.deadletter", "otf.ingest.control", "otf.events"}
+
+	cases := []struct {
+		name     string
+		list     string
+		expected []string
+	}{
+		{
+			name:     "default",
+			expected: []string{"otf.ingest", "otf.ingest.>", "otf.deadletter", "otf.events"},
+		},
+		{
+			name:     "given",
+			list:     " otf.> , ,otf.deadletter",
+			expected: []string{"otf.>", "otf.deadletter"},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			subjects := jetStreamSubjects(c.list, "otf.ingest", others)
+			if !reflect.DeepEqual(subjects, c.expected) {
+				t.Errorf("subjects are %v, expected %v", subjects, c.expected)
+			}
+		})
+	}
+}
+
+func TestCheckStreamSubjects(t *testing.T) {
+
+	topics := []string{"otf.ingest", "otf.deadletter"}
+	if err := checkStreamSubjects("OTF", []string{"otf.>"}, topics); err != nil {
+		t.Error(err)
+	}
+	err := checkStreamSubjects("OTF", []string{"otf.ingest", "otf.ingest.>"}, topics)
+	if err == nil || !strings.Contains(err.Error(), "does not capture topic otf.deadletter") {
+		t.Errorf("error is %v, expected otf.deadletter not to be captured", err)
+	}
+}
@@ -0,0 +1,138 @@
+package otfreader
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+//
+// splits a json array into its members without parsing them,
+// by tracking nesting and strings, so that a member with bad
+// json (eg. an unknown value) can be rejected without losing
+// the rest of the array.
+// a missing quote or brace can't be reliably recovered from, as
+// the quotes or braces after it are paired up wrongly. usually
+// the rest of the array then runs on to the end of the file,
+// which is reported as an error since the array is never closed.
+//
+type jsonArrayScanner struct {
+	r    *bufio.Reader
+	pos  int64 // bytes read so far
+	done bool
+}
+
+//
+// reads up to and including the opening [ of the array
+//
+func (as *jsonArrayScanner) start() error {
+	b, err := as.skipSpace()
+	if err == nil && b != '[' {
+		err = errors.Errorf("found %q", b)
+	}
+	if err != nil {
+		return errors.Wrap(err, "unexpected token; json file should be json array")
+	}
+	as.readByte()
+	return nil
+}
+
+//
+// returns the raw bytes of the next member of the array and
+// its byte offset in the file, or io.EOF at the end of the array.
+// errors if the file ends before the array is closed.
+//
+func (as *jsonArrayScanner) next() ([]byte, int64, error) {
+
+	if as.done {
+		return nil, 0, io.EOF
+	}
+	b, err := as.skipSpace()
+	if err == io.EOF {
+		as.done = true
+		return nil, 0, errors.New("file ends before the array is closed")
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	if b == ']' {
+		as.done = true
+		return nil, 0, io.EOF
+	}
+
+	offset := as.pos
+	var buf bytes.Buffer
+	depth := 0
+	inString, escaped := false, false
+	for {
+		b, err := as.readByte()
+		if err == io.EOF {
+			as.done = true
+			return nil, 0, errors.Errorf("file ends before the array is closed, in the member at byte %d", offset)
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+
+		if inString {
+			buf.WriteByte(b)
+			switch {
+			case escaped:
+				escaped = false
+			case b == '\\':
+				escaped = true
+			case b == '"':
+				inString = false
+			}
+			continue
+		}
+
+		switch b {
+		case '"':
+			inString = true
+		case '{', '[':
+			depth++
+		case '}', ']':
+			if depth == 0 && b == ']' {
+				as.done = true
+				return bytes.TrimSpace(buf.Bytes()), offset, nil
+			}
+			if depth > 0 {
+				depth--
+			}
+		case ',':
+			if depth == 0 {
+				return bytes.TrimSpace(buf.Bytes()), offset, nil
+			}
+		}
+		buf.WriteByte(b)
+	}
+}
+
+//
+// skips whitespace, returning the next byte without reading it
+//
+func (as *jsonArrayScanner) skipSpace() (byte, error) {
+	for {
+		p, err := as.r.Peek(1)
+		if err != nil {
+			return 0, err
+		}
+		switch p[0] {
+		case ' ', '\t', '\r', '\n':
+			as.readByte()
+		default:
+			return p[0], nil
+		}
+	}
+}
+
+func (as *jsonArrayScanner) readByte() (byte, error) {
+	b, err := as.r.ReadByte()
+	if err == nil {
+		as.pos++
+	}
+	return b, err
+}
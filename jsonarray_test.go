@@ -0,0 +1,100 @@
+package otfreader
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadJSONArray(t *testing.T) {
+
+	cases := []struct {
+		name     string
+		input    string
+		records  []string
+		rejected []string
+		err      string // part of the expected error, if the file fails
+	}{
+		{
+			name:    "members",
+			input:   ` [ {"a":1}, {"b":[1,2,{"c":"]"}]}, "x", 3 ] `,
+			records: []string{`{"a":1}`, `{"b":[1,2,{"c":"]"}]}`, `"x"`, `3`},
+		},
+		{
+			name:    "empty array",
+			input:   "[\n]\n",
+			records: []string{},
+		},
+		{
+			name:    "escaped quotes and braces in strings",
+			input:   `[{"a":"say \"}{\""},{"b":"\\"}]`,
+			records: []string{`{"a":"say \"}{\""}`, `{"b":"\\"}`},
+		},
+		{
+			name:     "invalid member rejected",
+			input:    `[{"a":1},{"b":nope},{"c":2}]`,
+			records:  []string{`{"a":1}`, `{"c":2}`},
+			rejected: []string{"array member 2 at byte 9"},
+		},
+		{
+			name:    "missing brace fails the file",
+			input:   `[{"a":1},{"b":2,{"c":3}]`,
+			records: []string{`{"a":1}`},
+			err:     "before the array is closed, in the member at byte 9",
+		},
+		{
+			name:    "missing quote fails the file",
+			input:   `[{"a":1},{"a":"x},{"b":1},{"c":2}]`,
+			records: []string{`{"a":1}`},
+			err:     "before the array is closed, in the member at byte 9",
+		},
+		{
+			name:    "array not closed",
+			input:   `[{"a":1},{"b":2},`,
+			records: []string{`{"a":1}`, `{"b":2}`},
+			err:     "before the array is closed",
+		},
+		{
+			name:  "not an array",
+			input: `{"a":1}`,
+			err:   "json file should be json array",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			records := []string{}
+			rejected := []string{}
+			handler := func(m []byte, meta ...metaField) error {
+				for _, mf := range meta {
+					if br, ok := mf.value.(*badRecord); ok {
+						rejected = append(rejected, br.offset)
+						return nil
+					}
+				}
+				records = append(records, string(m))
+				return nil
+			}
+
+			err := readJSON(strings.NewReader(c.input), nil, handler)
+			switch {
+			case c.err == "" && err != nil:
+				t.Fatal(err)
+			case c.err != "" && (err == nil || !strings.Contains(err.Error(), c.err)):
+				t.Fatalf("error is %v, expected %q", err, c.err)
+			}
+			if c.records == nil {
+				c.records = []string{}
+			}
+			if !reflect.DeepEqual(records, c.records) {
+				t.Errorf("records are\n%v\nexpected\n%v", records, c.records)
+			}
+			if c.rejected == nil {
+				c.rejected = []string{}
+			}
+			if !reflect.DeepEqual(rejected, c.rejected) {
+				t.Errorf("rejected %v, expected %v", rejected, c.rejected)
+			}
+		})
+	}
+}
@@ -0,0 +1,222 @@
+package otfreader
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+	bolt "go.etcd.io/bbolt"
+)
+
+//
+// name of the ledger database in the state folder
+//
+const ledgerFile = "ledger.db"
+
+var ledgerBucket = []byte("files")
+
+//
+// record fingerprints of files read in delta mode, keyed by path
+//
+var recordsBucket = []byte("records")
+
+//
+// outcomes recorded in the ledger
+//
+const (
+	outcomePublished = "published"
+	outcomeFailed    = "failed"
+)
+
+//
+// records each file the reader has processed in an embedded
+// key-value store in the state folder, so that a file copied
+// in again with the same content is not published twice, even
+// after the reader is restarted.
+// all methods can be called on a nil ledger (when no state
+// folder is configured), which records nothing.
+//
+type ledger struct {
+	db *bolt.DB
+}
+
+//
+// the ledger record for a file, keyed by path
+//
+type ledgerEntry struct {
+	Path         string    `json:"path"`
+	Size         int64     `json:"size"`
+	ModTime      time.Time `json:"modTime"`
+	SHA256       string    `json:"sha256"`
+	BatchID      string    `json:"batchID"`
+	Outcome      string    `json:"outcome"`
+	Error        string    `json:"error,omitempty"`
+	Published    int64     `json:"published"`
+	Failed       int64     `json:"failed"`
+	Rejected     int64     `json:"rejected"`
+	ProcessedUTC time.Time `json:"processedUTC"`
+}
+
+//
+// opens (or creates) the ledger in the state folder
+//
+func openLedger(dir string) (*ledger, error) {
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, errors.Wrap(err, "unable to create state folder")
+	}
+	// the database is locked while open, so fail rather than
+	// wait if another reader is using the same state folder
+	db, err := bolt.Open(filepath.Join(dir, ledgerFile), 0644, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to open ledger in "+dir)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, b := range [][]byte{ledgerBucket, recordsBucket} {
+			if _, err := tx.CreateBucketIfNotExists(b); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, errors.Wrap(err, "unable to create ledger")
+	}
+
+	return &ledger{db: db}, nil
+}
+
+//
+// the entry for the path, false if the file is not in the ledger
+//
+func (l *ledger) get(path string) (ledgerEntry, bool, error) {
+
+	var e ledgerEntry
+	if l == nil {
+		return e, false, nil
+	}
+	found := false
+	err := l.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(ledgerBucket).Get([]byte(path))
+		if v == nil {
+			return nil
+		}
+		found = true
+		return json.Unmarshal(v, &e)
+	})
+	return e, found, err
+}
+
+func (l *ledger) put(e ledgerEntry) error {
+	if l == nil {
+		return nil
+	}
+	v, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	return l.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(ledgerBucket).Put([]byte(e.Path), v)
+	})
+}
+
+//
+// the record fingerprints saved when the file was last
+// read in delta mode, nil if there are none
+//
+func (l *ledger) getRecords(path string) (map[string]recordPrint, error) {
+
+	if l == nil {
+		return nil, nil
+	}
+	var prints map[string]recordPrint
+	err := l.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(recordsBucket).Get([]byte(path))
+		if v == nil {
+			return nil
+		}
+		return json.Unmarshal(v, &prints)
+	})
+	return prints, err
+}
+
+func (l *ledger) putRecords(path string, prints map[string]recordPrint) error {
+	if l == nil {
+		return nil
+	}
+	v, err := json.Marshal(prints)
+	if err != nil {
+		return err
+	}
+	return l.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(recordsBucket).Put([]byte(path), v)
+	})
+}
+
+//
+// forgets a removed file (and its record fingerprints), so the
+// same content is published again if the file is copied back in
+//
+func (l *ledger) remove(path string) error {
+	if l == nil {
+		return nil
+	}
+	return l.db.Update(func(tx *bolt.Tx) error {
+		for _, b := range [][]byte{ledgerBucket, recordsBucket} {
+			if err := tx.Bucket(b).Delete([]byte(path)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+//
+// moves the entry (and record fingerprints) of a
+// renamed or moved file to its new path
+//
+func (l *ledger) move(oldPath, newPath string) error {
+	if l == nil {
+		return nil
+	}
+	return l.db.Update(func(tx *bolt.Tx) error {
+		rb := tx.Bucket(recordsBucket)
+		if v := rb.Get([]byte(oldPath)); v != nil {
+			if err := rb.Put([]byte(newPath), append([]byte(nil), v...)); err != nil {
+				return err
+			}
+			if err := rb.Delete([]byte(oldPath)); err != nil {
+				return err
+			}
+		}
+
+		b := tx.Bucket(ledgerBucket)
+		v := b.Get([]byte(oldPath))
+		if v == nil {
+			return nil
+		}
+		var e ledgerEntry
+		if err := json.Unmarshal(v, &e); err != nil {
+			return err
+		}
+		e.Path = newPath
+		nv, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		if err := b.Put([]byte(newPath), nv); err != nil {
+			return err
+		}
+		return b.Delete([]byte(oldPath))
+	})
+}
+
+func (l *ledger) close() error {
+	if l == nil {
+		return nil
+	}
+	return l.db.Close()
+}
@@ -0,0 +1,174 @@
+package otfreader
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func openTestLedger(t *testing.T) (*ledger, string) {
+	dir, err := ioutil.TempDir("", "otf-reader-state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	l, err := openLedger(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return l, dir
+}
+
+func TestLedger(t *testing.T) {
+
+	l, dir := openTestLedger(t)
+	entry := ledgerEntry{
+		Path:         "/data/results.csv",
+		Size:         42,
+		ModTime:      time.Date(2020, 7, 16, 10, 15, 0, 0, time.UTC),
+		SHA256:       "abc",
+		BatchID:      "b1",
+		Outcome:      outcomePublished,
+		Published:    3,
+		ProcessedUTC: time.Date(2020, 7, 16, 10, 16, 0, 0, time.UTC),
+	}
+	prints := map[string]recordPrint{"otf.ingest||x#1": {Topic: "otf.ingest", Hash: "x"}}
+	if err := l.put(entry); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.putRecords(entry.Path, prints); err != nil {
+		t.Fatal(err)
+	}
+
+	// the database is locked by the open ledger
+	if _, err := openLedger(dir); err == nil {
+		t.Error("ledger opened twice")
+	}
+
+	// kept when the reader restarts
+	l.close()
+	l, err := openLedger(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.close()
+	got, found, err := l.get(entry.Path)
+	if err != nil || !found || !reflect.DeepEqual(got, entry) {
+		t.Errorf("entry is %+v (found %v, %v), expected %+v", got, found, err, entry)
+	}
+
+	if err := l.move(entry.Path, "/data/moved.csv"); err != nil {
+		t.Fatal(err)
+	}
+	if _, found, _ := l.get(entry.Path); found {
+		t.Error("entry still at old path")
+	}
+	got, found, _ = l.get("/data/moved.csv")
+	if !found || got.Path != "/data/moved.csv" || got.SHA256 != "abc" {
+		t.Errorf("moved entry is %+v", got)
+	}
+	if p, _ := l.getRecords("/data/moved.csv"); !reflect.DeepEqual(p, prints) {
+		t.Errorf("moved fingerprints are %v", p)
+	}
+	if p, _ := l.getRecords(entry.Path); p != nil {
+		t.Errorf("fingerprints still at old path")
+	}
+
+	if err := l.remove("/data/moved.csv"); err != nil {
+		t.Fatal(err)
+	}
+	if _, found, _ := l.get("/data/moved.csv"); found {
+		t.Error("removed entry found")
+	}
+	if p, _ := l.getRecords("/data/moved.csv"); p != nil {
+		t.Error("removed fingerprints found")
+	}
+	if err := l.move("/data/missing.csv", "/data/other.csv"); err != nil {
+		t.Errorf("moving a missing entry: %v", err)
+	}
+}
+
+func TestNilLedger(t *testing.T) {
+
+	var l *ledger
+	if err := l.put(ledgerEntry{Path: "a"}); err != nil {
+		t.Error(err)
+	}
+	if _, found, err := l.get("a"); found || err != nil {
+		t.Errorf("found %v, %v", found, err)
+	}
+	if p, err := l.getRecords("a"); p != nil || err != nil {
+		t.Errorf("records %v, %v", p, err)
+	}
+	if err := l.move("a", "b"); err != nil {
+		t.Error(err)
+	}
+	if err := l.remove("a"); err != nil {
+		t.Error(err)
+	}
+	if err := l.close(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestPublishFileLedger(t *testing.T) {
+
+	cases := []struct {
+		name      string
+		failFirst bool // the first read fails to publish
+		change    bool // the file is changed before it is read again
+		force     bool
+		published int // records published by the second read
+	}{
+		{name: "same content skipped"},
+		{name: "changed content", change: true, published: 3},
+		{name: "forced", force: true, published: 3},
+		{name: "failed before", failFirst: true, published: 3},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			fail := c.failFirst
+			p := &memPublisher{publishErr: func(topic string, msg []byte) error {
+				if fail && topic == "otf.ingest" {
+					return errors.New("not connected")
+				}
+				return nil
+			}}
+			rdr := newTestReader(t, p)
+			rdr.ledger, _ = openTestLedger(t)
+			defer rdr.ledger.close()
+
+			path := writeTestFile(t, "results.csv", testCSV)
+			err := publishTestFile(t, rdr, path)
+			if (err != nil) != c.failFirst {
+				t.Fatalf("first read returned %v", err)
+			}
+			entry, found, _ := rdr.ledger.get(path)
+			if !found || (entry.Outcome == outcomeFailed) != c.failFirst {
+				t.Fatalf("ledger entry is %+v", entry)
+			}
+			if !c.failFirst && (entry.Published != 3 || entry.Size != int64(len(testCSV)) || entry.BatchID == "") {
+				t.Errorf("ledger entry is %+v", entry)
+			}
+
+			fail = false
+			if c.change {
+				ioutil.WriteFile(path, []byte(testCSV+"4,Alan\n"), 0644)
+			}
+			before := len(p.topic("otf.ingest"))
+			rdr.publishFile(path, c.force)
+			published := len(p.topic("otf.ingest")) - before
+			if c.change {
+				// the new row as well
+				published--
+			}
+			if published != c.published {
+				t.Errorf("%d records published, expected %d", published, c.published)
+			}
+		})
+	}
+}
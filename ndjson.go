@@ -0,0 +1,63 @@
+package otfreader
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+
+	"github.com/pkg/errors"
+)
+
+//
+// reads newline-delimited json (ndjson/json lines), each line
+// is handed to the record handler as one record.
+// blank lines are skipped, and lines that are not valid json
+// are rejected with their line number so that the rest of the
+// file is still published.
+// if a record selector is given it is applied to each line.
+//
+func readNDJSON(r io.Reader, fileName string, sel *recordSelector, handler recordHandler) error {
+
+	br := bufio.NewReader(r)
+	lineNo := 0
+	badLines := 0
+	for {
+		line, err := br.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return errors.Wrapf(err, "unable to read line %d", lineNo+1)
+		}
+		if len(line) > 0 {
+			lineNo++
+			line = bytes.TrimSpace(line)
+			if len(line) > 0 {
+				if json.Valid(line) {
+					var herr error
+					if sel != nil {
+						herr = sel.selectRecords(line, handler)
+					} else {
+						herr = handler(line)
+					}
+					if herr != nil {
+						return herr
+					}
+				} else {
+					badLines++
+					if herr := rejectRecord(handler, line, fmt.Sprintf("line %d", lineNo), errors.New("invalid json")); herr != nil {
+						return herr
+					}
+				}
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+	}
+
+	if badLines > 0 {
+		log.Printf("Warning: %d invalid line(s) skipped in %s\n", badLines, fileName)
+	}
+	return nil
+}
@@ -0,0 +1,259 @@
+package otfreader
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/nsip/otf-reader/internal/util"
+	"github.com/pkg/errors"
+)
+
+//
+// name of the file that describes a oneroster csv bundle
+//
+const oneRosterManifest = "manifest.csv"
+
+//
+// reads a oneroster 1.1 csv bundle, either a folder (when the
+// manifest.csv in it is created or updated) or a zip file.
+// the manifest lists which entity files (users, results, lineItems...)
+// are present; the rows of each are published to the reader topic
+// with the entity name appended (eg. otf.ingest.results), and tagged
+// with the entity type in the meta-data.
+// other files in a bundle folder are skipped (see bundleMember),
+// as they are read along with the manifest.
+//
+func (rdr *OtfReader) readOneRoster(f *os.File, fileName string, publishTo func(topic string) recordHandler) error {
+
+	br := bufio.NewReader(f)
+	head, _ := br.Peek(len(zipMagic))
+	switch {
+	case bytes.Equal(head, zipMagic):
+		fi, err := f.Stat()
+		if err != nil {
+			return err
+		}
+		zr, err := zip.NewReader(f, fi.Size())
+		if err != nil {
+			return errors.Wrap(err, "unable to open oneroster zip file")
+		}
+		return rdr.readOneRosterZip(zr, publishTo)
+
+	case strings.EqualFold(filepath.Base(fileName), oneRosterManifest):
+		dir := filepath.Dir(fileName)
+		open := func(name string) (io.ReadCloser, string, error) {
+			p := filepath.Join(dir, name)
+			ef, err := os.Open(p)
+			return ef, p, err
+		}
+		return rdr.readOneRosterBundle(br, open, publishTo)
+	}
+
+	return errors.Errorf("%s is not a oneroster %s or zip file", fileName, oneRosterManifest)
+}
+
+//
+// true for the files of a oneroster bundle folder other than
+// the manifest, which are only read along with the manifest
+// and so are skipped by publishFile (with no ledger entry or
+// control messages, as no batch is published for them)
+//
+func (rdr *OtfReader) bundleMember(fileName string) bool {
+
+	if rdr.inputFormat != "oneroster" || strings.EqualFold(filepath.Base(fileName), oneRosterManifest) {
+		return false
+	}
+	f, err := os.Open(fileName)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	head := make([]byte, len(zipMagic))
+	n, _ := io.ReadFull(f, head)
+	return !bytes.Equal(head[:n], zipMagic)
+}
+
+//
+// finds the manifest in a zipped bundle (at the top level
+// or in a single folder) and reads the bundle from the zip
+//
+func (rdr *OtfReader) readOneRosterZip(zr *zip.Reader, publishTo func(topic string) recordHandler) error {
+
+	members := map[string]*zip.File{}
+	var manifest *zip.File
+	for _, zf := range zr.File {
+		members[zf.Name] = zf
+		if strings.EqualFold(path.Base(zf.Name), oneRosterManifest) && (manifest == nil || len(zf.Name) < len(manifest.Name)) {
+			manifest = zf
+		}
+	}
+	if manifest == nil {
+		return errors.New("zip file is not a oneroster bundle, no " + oneRosterManifest + " found")
+	}
+	dir := path.Dir(manifest.Name)
+
+	open := func(name string) (io.ReadCloser, string, error) {
+		p := path.Join(dir, name)
+		zf, ok := members[p]
+		if !ok {
+			return nil, p, os.ErrNotExist
+		}
+		rc, err := zf.Open()
+		return rc, p, err
+	}
+
+	mr, err := manifest.Open()
+	if err != nil {
+		return errors.Wrap(err, "unable to open "+oneRosterManifest)
+	}
+	defer mr.Close()
+	return rdr.readOneRosterBundle(mr, open, publishTo)
+}
+
+//
+// reads the manifest, then publishes each entity file it lists
+// as bulk or delta. open returns an entity file from the bundle
+// by name, along with its full path for reporting.
+//
+func (rdr *OtfReader) readOneRosterBundle(manifest io.Reader, open func(name string) (io.ReadCloser, string, error), publishTo func(topic string) recordHandler) error {
+
+	props, err := rdr.oneRosterProps(manifest)
+	if err != nil {
+		return err
+	}
+
+	version := ""
+	for _, p := range props {
+		if p[0] == "oneroster.version" {
+			version = p[1]
+		}
+	}
+
+	failed := 0
+	for _, p := range props {
+		entity, mode, ok := oneRosterFile(p)
+		if !ok {
+			continue
+		}
+
+		if err := rdr.readOneRosterEntity(entity, mode, version, open, publishTo); err != nil {
+			failed++
+			log.Printf("error reading oneroster %s: %v\n", entity, err)
+		}
+	}
+
+	if failed > 0 {
+		return errors.Errorf("%d oneroster file(s) could not be read", failed)
+	}
+	return nil
+}
+
+//
+// the property name and value of each row of the manifest
+//
+func (rdr *OtfReader) oneRosterProps(manifest io.Reader) ([][2]string, error) {
+
+	manifest, err := decodeInput(manifest, rdr.encoding)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to decode "+oneRosterManifest)
+	}
+
+	props := [][2]string{}
+	err = readCSV(manifest, oneRosterManifest, defaultCSVDialect, nil, func(m []byte, _ ...metaField) error {
+		var row struct {
+			PropertyName string `json:"propertyName"`
+			Value        string `json:"value"`
+		}
+		if err := json.Unmarshal(m, &row); err != nil {
+			return err
+		}
+		props = append(props, [2]string{row.PropertyName, row.Value})
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to read "+oneRosterManifest)
+	}
+	return props, nil
+}
+
+//
+// the entity and mode (bulk or delta) of a manifest file.*
+// property, false for other properties and absent files
+//
+func oneRosterFile(p [2]string) (string, string, bool) {
+	if !strings.HasPrefix(p[0], "file.") {
+		return "", "", false
+	}
+	mode := strings.ToLower(strings.TrimSpace(p[1]))
+	if mode != "bulk" && mode != "delta" {
+		return "", "", false
+	}
+	return strings.TrimPrefix(p[0], "file."), mode, true
+}
+
+//
+// the entity files listed in the manifest of a bundle folder,
+// which have to settle along with the manifest before the
+// bundle is read. nil for any other file, or a manifest that
+// cannot be read yet (it is checked again as it settles).
+//
+func (rdr *OtfReader) bundleFiles(fileName string) []string {
+
+	if rdr.inputFormat != "oneroster" || !strings.EqualFold(filepath.Base(fileName), oneRosterManifest) {
+		return nil
+	}
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	props, err := rdr.oneRosterProps(f)
+	if err != nil {
+		return nil
+	}
+
+	dir := filepath.Dir(fileName)
+	files := []string{}
+	for _, p := range props {
+		if entity, _, ok := oneRosterFile(p); ok {
+			files = append(files, filepath.Join(dir, entity+".csv"))
+		}
+	}
+	return files
+}
+
+//
+// publishes the rows of a single entity file
+//
+func (rdr *OtfReader) readOneRosterEntity(entity, mode, version string, open func(name string) (io.ReadCloser, string, error), publishTo func(topic string) recordHandler) error {
+
+	topic := rdr.publishTopic + "." + entity
+	if ok, err := util.ValidateNatsTopic(topic); !ok {
+		return errors.Wrap(err, "invalid oneroster entity topic "+topic)
+	}
+
+	rc, filePath, err := open(entity + ".csv")
+	if err != nil {
+		return errors.Wrap(err, "unable to open "+entity+".csv")
+	}
+	defer rc.Close()
+
+	publish := publishTo(topic)
+	handler := func(m []byte, meta ...metaField) error {
+		return publish(m, append(meta,
+			metaField{name: "oneRosterEntity", value: entity},
+			metaField{name: "oneRosterMode", value: mode},
+			metaField{name: "oneRosterVersion", value: version},
+			metaField{name: "oneRosterFile", value: filePath},
+		)...)
+	}
+	return rdr.readInput(rc, filePath, handler)
+}
@@ -0,0 +1,249 @@
+package otfreader
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testManifest = "propertyName,value\noneroster.version,1.1\nfile.users,bulk\nfile.results,Delta\nfile.orgs,absent\n"
+
+//
+// writes the files to a new folder, returning its path
+//
+func writeTestFolder(t *testing.T, files map[string]string) string {
+	dir, err := ioutil.TempDir("", "otf-reader")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	for name, content := range files {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(p), 0755)
+		if err := ioutil.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func newOneRosterReader(t *testing.T, p Publisher) *OtfReader {
+	rdr := newTestReader(t, p)
+	rdr.inputFormat = "oneroster"
+	return rdr
+}
+
+//
+// the entity, mode and sourcedId of each record published,
+// sorted as entity files are read in manifest order
+//
+func oneRosterRecords(t *testing.T, p *memPublisher) []string {
+	records := []string{}
+	for _, m := range p.msgs {
+		if m.topic == "otf.control" {
+			continue
+		}
+		var msg struct {
+			Meta     map[string]interface{} `json:"meta"`
+			Original map[string]interface{} `json:"original"`
+		}
+		if err := json.Unmarshal(m.msg, &msg); err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasSuffix(m.topic, "."+msg.Meta["oneRosterEntity"].(string)) {
+			t.Errorf("%s record published to %s", msg.Meta["oneRosterEntity"], m.topic)
+		}
+		if msg.Meta["oneRosterVersion"] != "1.1" {
+			t.Errorf("version is %v", msg.Meta["oneRosterVersion"])
+		}
+		records = append(records, strings.Join([]string{
+			m.topic, msg.Meta["oneRosterMode"].(string), msg.Original["sourcedId"].(string),
+			filepath.Base(msg.Meta["oneRosterFile"].(string)),
+		}, " "))
+	}
+	sort.Strings(records)
+	return records
+}
+
+func TestOneRosterBundles(t *testing.T) {
+
+	users := "sourcedId,givenName\nu1,Ada\nu2,Grace\n"
+	results := "sourcedId,score\nr1,10\n"
+
+	cases := []struct {
+		name    string
+		files   map[string]string
+		read    string // file that is published
+		zip     bool   // read is a zip of the files
+		records []string
+		err     string
+	}{
+		{
+			name:  "folder",
+			files: map[string]string{"manifest.csv": testManifest, "users.csv": users, "results.csv": results, "orgs.csv": "sourcedId\no1\n"},
+			read:  "manifest.csv",
+			records: []string{
+				"otf.ingest.results delta r1 results.csv",
+				"otf.ingest.users bulk u1 users.csv",
+				"otf.ingest.users bulk u2 users.csv",
+			},
+		},
+		{
+			name:  "zip in a folder",
+			files: map[string]string{"bundle/manifest.csv": testManifest, "bundle/users.csv": users, "bundle/results.csv": results},
+			read:  "bundle.zip",
+			zip:   true,
+			records: []string{
+				"otf.ingest.results delta r1 results.csv",
+				"otf.ingest.users bulk u1 users.csv",
+				"otf.ingest.users bulk u2 users.csv",
+			},
+		},
+		{
+			name:    "missing entity file",
+			files:   map[string]string{"manifest.csv": testManifest, "users.csv": users},
+			read:    "manifest.csv",
+			records: []string{"otf.ingest.users bulk u1 users.csv", "otf.ingest.users bulk u2 users.csv"},
+			err:     "1 oneroster file(s) could not be read",
+		},
+		{
+			name:    "zip with no manifest",
+			files:   map[string]string{"users.csv": users},
+			read:    "bundle.zip",
+			zip:     true,
+			records: []string{},
+			err:     "no manifest.csv found",
+		},
+		{
+			name:    "entity file",
+			files:   map[string]string{"manifest.csv": testManifest, "users.csv": users},
+			read:    "users.csv",
+			records: []string{},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			p := &memPublisher{}
+			rdr := newOneRosterReader(t, p)
+			dir := writeTestFolder(t, c.files)
+			if c.zip {
+				members := []testMember{}
+				for name, content := range c.files {
+					members = append(members, testMember{name, []byte(content)})
+				}
+				dir = writeTestFolder(t, map[string]string{c.read: string(zipBytes(t, members...))})
+			}
+
+			err := publishTestFile(t, rdr, filepath.Join(dir, c.read))
+			switch {
+			case c.err == "" && err != nil:
+				t.Fatal(err)
+			case c.err != "" && (err == nil || !strings.Contains(err.Error(), c.err)):
+				t.Fatalf("error is %v, expected %q", err, c.err)
+			}
+			if records := oneRosterRecords(t, p); !reflect.DeepEqual(records, c.records) {
+				t.Errorf("records are\n%v\nexpected\n%v", records, c.records)
+			}
+			if c.read == "users.csv" && len(p.msgs) > 0 {
+				// no batch, so no control messages either
+				t.Errorf("%d messages published for an entity file", len(p.msgs))
+			}
+		})
+	}
+}
+
+func TestOneRosterBundleChanges(t *testing.T) {
+
+	state, err := ioutil.TempDir("", "otf-reader-state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(state)
+
+	p := &memPublisher{}
+	rdr := newOneRosterReader(t, p)
+	if rdr.ledger, err = openLedger(state); err != nil {
+		t.Fatal(err)
+	}
+	defer rdr.ledger.close()
+
+	dir := writeTestFolder(t, map[string]string{"manifest.csv": testManifest, "users.csv": "sourcedId\nu1\n", "results.csv": "sourcedId\nr1\n"})
+	manifest := filepath.Join(dir, "manifest.csv")
+	read := func() int {
+		if err := publishTestFile(t, rdr, manifest); err != nil {
+			t.Fatal(err)
+		}
+		return len(p.topic("otf.ingest.users"))
+	}
+
+	if n := read(); n != 1 {
+		t.Fatalf("%d users published, expected 1", n)
+	}
+	// the same manifest, but a new user
+	ioutil.WriteFile(filepath.Join(dir, "users.csv"), []byte("sourcedId\nu1\nu2\n"), 0644)
+	if n := read(); n != 3 {
+		t.Fatalf("%d users published, expected the bundle to be read again", n)
+	}
+	msgs := p.topic("otf.ingest.users")
+	if messageID(t, msgs[0]) == messageID(t, msgs[1]) {
+		t.Error("message ids are the same for different content")
+	}
+	// nothing has changed
+	if n := read(); n != 3 {
+		t.Fatalf("%d users published, expected the bundle to be skipped", n)
+	}
+}
+
+func messageID(t *testing.T, msg []byte) string {
+	var m struct {
+		Meta struct {
+			MessageID string `json:"messageID"`
+		} `json:"meta"`
+	}
+	if err := json.Unmarshal(msg, &m); err != nil {
+		t.Fatal(err)
+	}
+	return m.Meta.MessageID
+}
+
+func TestOneRosterBundleFiles(t *testing.T) {
+
+	rdr := newOneRosterReader(t, &memPublisher{})
+	dir := writeTestFolder(t, map[string]string{"manifest.csv": testManifest, "users.csv": "sourcedId\n"})
+	manifest := filepath.Join(dir, "manifest.csv")
+
+	files := rdr.bundleFiles(manifest)
+	expected := []string{filepath.Join(dir, "users.csv"), filepath.Join(dir, "results.csv")}
+	if !reflect.DeepEqual(files, expected) {
+		t.Errorf("bundle files are %v, expected %v", files, expected)
+	}
+	if files := rdr.bundleFiles(filepath.Join(dir, "users.csv")); files != nil {
+		t.Errorf("entity file has bundle files %v", files)
+	}
+
+	// not settled until every listed file is there, and old enough
+	rdr.settleMode = "stable"
+	rdr.settleChecks = 2
+	rdr.interval = time.Millisecond
+	time.Sleep(10 * time.Millisecond)
+	if rdr.settledNow(manifest) {
+		t.Error("settled with results.csv missing")
+	}
+	ioutil.WriteFile(expected[1], []byte("sourcedId\n"), 0644)
+	rdr.interval = time.Hour
+	if rdr.settledNow(manifest) {
+		t.Error("settled with results.csv just written")
+	}
+	rdr.interval = time.Millisecond
+	time.Sleep(10 * time.Millisecond)
+	if !rdr.settledNow(manifest) {
+		t.Error("not settled")
+	}
+}
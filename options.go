@@ -1,6 +1,8 @@
 package otfreader
 
 import (
+	"net/http"
+	neturl "net/url"
 	"os"
 	"regexp"
 	"strings"
@@ -73,7 +75,8 @@ func ProviderName(name string) Option {
 
 //
 // the format of the input data, currently supported foramts
-// are; csv, json & xml
+// are; csv, json, ndjson (or jsonl), xml, xlsx & oneroster
+// (oneroster 1.1 csv bundles, as a folder or zip file)
 //
 func InputFormat(iformat string) Option {
 	return func(rdr *OtfReader) error {
@@ -84,14 +87,172 @@ func InputFormat(iformat string) Option {
 		format := strings.ToLower(iformat)
 		trimFormat := strings.Trim(format, ".") // remove any ecess . chars
 		switch trimFormat {
-		case "csv", "json":
+		case "csv", "json", "xml", "ndjson", "xlsx", "oneroster":
 			rdr.inputFormat = trimFormat
 			return nil
+		case "jsonl":
+			rdr.inputFormat = "ndjson"
+			return nil
 		}
-		return errors.New("otf-reader InputFormat " + iformat + " not supported (must be one of csv|json)")
+		return errors.New("otf-reader InputFormat " + iformat + " not supported (must be one of csv|json|ndjson|jsonl|xml|xlsx|oneroster)")
 	}
 }
 
+//
+// give json types to the values of csv and xlsx cells, which
+// are otherwise all published as strings.
+// infer - convert values that look like numbers or booleans
+// (true/false in any case), and empty cells to null
+// schema - comma-separated list of column:type pairs, types being
+// one of string|number|integer|boolean, eg. score:number,id:string
+// schema types take precedence over inferred types.
+//
+func CSVTypes(infer bool, schema string) Option {
+	return func(rdr *OtfReader) error {
+		ct, err := parseColumnTypes(infer, schema)
+		if err != nil {
+			return errors.Wrap(err, "CSVTypes option error")
+		}
+		rdr.columnTypes = ct
+		return nil
+	}
+}
+
+//
+// select the sheet to read from excel (.xlsx) input, and the row
+// holding the column names. sheet can be a sheet name or 1-based
+// index, empty for the first sheet. headerRow is 1-based, values
+// of 0 or less will result in the default of 1.
+//
+func XLSXSheet(sheet string, headerRow int) Option {
+	return func(rdr *OtfReader) error {
+		rdr.xlsxSheet = strings.TrimSpace(sheet)
+		if headerRow > 0 {
+			rdr.xlsxHeaderRow = headerRow
+			return nil
+		}
+		rdr.xlsxHeaderRow = 1
+		return nil
+	}
+}
+
+//
+// the character encoding of input files, one of
+// auto, utf-8, utf-16le, utf-16be, windows-1252 or iso-8859-1
+// input is always transcoded to utf-8 and any byte order mark
+// removed before parsing. auto (the default) detects the encoding
+// from the byte order mark, or falls back to windows-1252 for
+// files that are not valid utf-8.
+//
+func Encoding(enc string) Option {
+	return func(rdr *OtfReader) error {
+		name, err := encodingName(enc)
+		if err != nil {
+			return errors.Wrap(err, "otf-reader Encoding option error")
+		}
+		rdr.encoding = name
+		return nil
+	}
+}
+
+//
+// path of the repeating element in xml input files that
+// holds each record, eg. /StudentPersonals/StudentPersonal
+// only used (and then required) when input format is xml
+//
+func XMLRecordPath(path string) Option {
+	return func(rdr *OtfReader) error {
+		rdr.xmlRecordPath = strings.TrimSpace(path)
+		return nil
+	}
+}
+
+//
+// select the records to publish from within nested json input
+// (json or ndjson formats), using a jq style path such as .[].allocations[]
+// carryFields is a comma-separated list of fields to copy from the
+// parent objects into each record, either as a path (school) or
+// as dest=src (test.name=name).
+// an empty selector publishes each top-level array member as normal.
+//
+func RecordSelector(selector string, carryFields string) Option {
+	return func(rdr *OtfReader) error {
+		if strings.TrimSpace(selector) == "" {
+			if strings.TrimSpace(carryFields) != "" {
+				return errors.New("otf-reader carry-down fields require a RecordSelector")
+			}
+			return nil
+		}
+		sel, err := parseRecordSelector(selector, carryFields)
+		if err != nil {
+			return errors.Wrap(err, "RecordSelector option error")
+		}
+		rdr.selector = sel
+		rdr.selectorExpr = selector
+		rdr.carryFields = carryFields
+		return nil
+	}
+}
+
+//
+// describe the layout of csv input files:
+// delimiter - field separator, a single character or \t (or tab), default ,
+// quote - quoting character, default "
+// comment - lines starting with this prefix are ignored, empty for none
+// skipLines - number of lines (eg. title banners) to skip before the header row
+// header - comma-separated column names for files that have no header row
+//
+func CSVDialect(delimiter, quote, comment string, skipLines int, header string) Option {
+	return func(rdr *OtfReader) error {
+
+		d := defaultCSVDialect
+
+		var err error
+		if d.delimiter, err = dialectRune(delimiter, ','); err != nil {
+			return errors.Wrap(err, "CSVDialect delimiter error")
+		}
+		if d.quote, err = dialectRune(quote, '"'); err != nil {
+			return errors.Wrap(err, "CSVDialect quote error")
+		}
+		if d.delimiter == d.quote {
+			return errors.New("CSVDialect delimiter and quote cannot be the same character")
+		}
+
+		d.comment = comment
+		if skipLines < 0 {
+			return errors.New("CSVDialect skipLines cannot be negative")
+		}
+		d.skipLines = skipLines
+
+		for _, h := range strings.Split(header, ",") {
+			if h = strings.TrimSpace(h); h != "" {
+				d.header = append(d.header, h)
+			}
+		}
+
+		rdr.csvDialect = d
+		return nil
+	}
+}
+
+//
+// converts a dialect setting to a single character,
+// allowing escaped/named forms for tab
+//
+func dialectRune(s string, def rune) (rune, error) {
+	switch s {
+	case "":
+		return def, nil
+	case "\\t", "tab", "TAB":
+		return '\t', nil
+	}
+	r := []rune(s)
+	if len(r) != 1 || r[0] == '\n' || r[0] == '\r' {
+		return 0, errors.Errorf("'%s' must be a single character", s)
+	}
+	return r[0], nil
+}
+
 //
 // select the levelling/scaling method appropriate for data from this vendor
 // can be one of
@@ -197,6 +358,151 @@ func NatsClusterName(clusterName string) Option {
 	}
 }
 
+//
+// select how otf messages are published, one of
+// stan: nats streaming server (the default)
+// jetstream: nats jetstream, see the JetStream option
+// file: write messages as ndjson to stdout or a file, see the FileSink option
+// http: post messages to a url, see the HTTPSink option
+//
+func PublisherType(ptype string) Option {
+	return func(rdr *OtfReader) error {
+		if ptype == "" {
+			rdr.publisherType = "stan"
+			return nil
+		}
+
+		pt := strings.ToLower(ptype)
+		switch pt {
+		case "stan", "jetstream", "file", "http":
+			rdr.publisherType = pt
+			return nil
+		}
+		return errors.New("otf-reader PublisherType " + ptype + " not supported (must be one of stan|jetstream|file|http)")
+	}
+}
+
+//
+// configure publishing to nats jetstream.
+// stream is the name of the stream to publish to, which will be
+// created if it does not exist yet, capturing the comma-separated list
+// of subjects (by default the reader topic, and all topics below it).
+// reader topics are used directly as jetstream subjects.
+// maxPending limits the number of messages waiting for acknowledgement
+// (default 4000).
+//
+func JetStream(stream string, subjects string, maxPending int) Option {
+	return func(rdr *OtfReader) error {
+		if stream != "" {
+			if strings.ContainsAny(stream, ". *>") {
+				return errors.New("otf-reader JetStream stream name cannot contain spaces, '.', '*' or '>'")
+			}
+			rdr.jsStream = stream
+		}
+		rdr.jsSubjects = subjects
+		if maxPending > 0 {
+			rdr.jsMaxPending = maxPending
+		}
+		return nil
+	}
+}
+
+//
+// configure writing messages as ndjson instead of publishing them.
+// path is the output file, empty or - for stdout.
+// rotateMB > 0 moves the output file aside (named with a timestamp)
+// and starts a new one once it reaches that size in megabytes.
+// withTopic writes each message along with the topic it would
+// have been published to.
+//
+func FileSink(path string, rotateMB int, withTopic bool) Option {
+	return func(rdr *OtfReader) error {
+		if path == "" {
+			path = "-"
+		}
+		if rotateMB < 0 {
+			return errors.New("otf-reader FileSink rotateMB cannot be negative")
+		}
+		rdr.outFile = path
+		rdr.outRotateMB = rotateMB
+		rdr.outTopics = withTopic
+		return nil
+	}
+}
+
+//
+// configure posting messages to an http endpoint.
+// headers is a comma-separated list of Name:Value headers sent
+// with every request, and token (if not empty) is sent as a
+// bearer token in the Authorization header.
+// batchSize > 1 posts that many messages at a time as a json array,
+// concurrency limits the number of posts in progress (default 4),
+// retries is the number of times a post that times out or gets a
+// server error is retried, backing off exponentially, and timeout
+// is the time allowed for each post (default 30s).
+//
+func HTTPSink(url string, headers string, token string, batchSize int, concurrency int, retries int, timeout string) Option {
+	return func(rdr *OtfReader) error {
+		if url != "" {
+			u, err := neturl.Parse(url)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return errors.New("otf-reader HTTPSink url " + url + " must be an absolute http or https url")
+			}
+		}
+		rdr.httpURL = url
+
+		h := http.Header{}
+		for _, hv := range strings.Split(headers, ",") {
+			if strings.TrimSpace(hv) == "" {
+				continue
+			}
+			parts := strings.SplitN(hv, ":", 2)
+			name := strings.TrimSpace(parts[0])
+			if len(parts) != 2 || name == "" {
+				return errors.New("otf-reader HTTPSink header " + hv + " must be given as Name:Value")
+			}
+			h.Add(name, strings.TrimSpace(parts[1]))
+		}
+		rdr.httpHeader = h
+		rdr.httpToken = token
+
+		if batchSize < 0 || concurrency < 0 || retries < 0 {
+			return errors.New("otf-reader HTTPSink batchSize, concurrency and retries cannot be negative")
+		}
+		if batchSize > 0 {
+			rdr.httpBatchSize = batchSize
+		}
+		if concurrency > 0 {
+			rdr.httpConcurrency = concurrency
+		}
+		rdr.httpRetries = retries
+
+		if timeout != "" {
+			d, err := time.ParseDuration(timeout)
+			if err != nil {
+				return errors.Wrap(err, "otf-reader HTTPSink timeout")
+			}
+			rdr.httpTimeout = d
+		}
+		return nil
+	}
+}
+
+//
+// supply the publisher that otf messages are sent to, instead
+// of the default nats streaming connection made from the
+// nats host, port and cluster options
+//
+func MessagePublisher(p Publisher) Option {
+	return func(rdr *OtfReader) error {
+		if p == nil {
+			return errors.New("otf-reader MessagePublisher cannot be nil.")
+		}
+		rdr.publisher = p
+		return nil
+	}
+}
+
 //
 // set the name of the nats topic to publish data once parsed
 // from the input files
@@ -218,6 +524,218 @@ func TopicName(tName string) Option {
 
 }
 
+//
+// route messages to topics picked from values in each message.
+// routes is a comma-separated list of templates, where {{path}} is
+// replaced by the value at that path in the otf message, eg.
+// otf.ingest.{{meta.capability}}.{{original.school.sector}}
+// the first template with all of its values present gives the topic,
+// otherwise messages go to defaultTopic, or the reader topic if
+// no default is given.
+//
+func TopicRoutes(routes string, defaultTopic string) Option {
+	return func(rdr *OtfReader) error {
+		tr, err := parseTopicRouter(routes, defaultTopic)
+		if err != nil {
+			return errors.Wrap(err, "TopicRoutes option error")
+		}
+		rdr.router = tr
+		rdr.topicRoutes = routes
+		return nil
+	}
+}
+
+//
+// send records that cannot be parsed or published to a
+// dead-letter topic, or (if no topic is given) append them to
+// an ndjson file per input file in the folder, along with the
+// file, position and error. bad records are always logged and
+// skipped, so that the rest of the file is still published.
+//
+func DeadLetter(topic string, folder string) Option {
+	return func(rdr *OtfReader) error {
+		if topic != "" {
+			if ok, err := util.ValidateNatsTopic(topic); !ok {
+				return errors.Wrap(err, "DeadLetter option error")
+			}
+		}
+		rdr.deadLetterTopic = topic
+		rdr.deadLetterDir = folder
+		return nil
+	}
+}
+
+//
+// publish fileStart and fileComplete control messages before
+// and after the records of each file, to the control topic if
+// given, otherwise the reader topic
+//
+func ControlMessages(enabled bool, topic string) Option {
+	return func(rdr *OtfReader) error {
+		if topic != "" {
+			if ok, err := util.ValidateNatsTopic(topic); !ok {
+				return errors.Wrap(err, "ControlMessages option error")
+			}
+		}
+		rdr.controlMsgs = enabled
+		rdr.controlTopic = topic
+		return nil
+	}
+}
+
+//
+// publish an event to the topic when a watched file is removed,
+// renamed or moved, with the hash and batch id of the file when
+// it was last read. no events are published if topic is empty.
+//
+func FileEvents(topic string) Option {
+	return func(rdr *OtfReader) error {
+		if topic != "" {
+			if ok, err := util.ValidateNatsTopic(topic); !ok {
+				return errors.Wrap(err, "FileEvents option error")
+			}
+		}
+		rdr.fileEventTopic = topic
+		return nil
+	}
+}
+
+//
+// keep a ledger of processed files in the state folder, so
+// that a file copied in again with the same content is not
+// published twice, even after a restart.
+// each reader needs its own state folder.
+//
+func StateFolder(dir string) Option {
+	return func(rdr *OtfReader) error {
+		rdr.stateDir = dir
+		return nil
+	}
+}
+
+//
+// choose which files already in the watched folder are published
+// when the reader starts, before watching for changes, one of
+// all: every existing file
+// unprocessed: files not already published according to the
+// ledger (see StateFolder), or all files if there is no ledger
+// none: no existing files (the default)
+//
+func Backlog(mode string) Option {
+	return func(rdr *OtfReader) error {
+		if mode == "" {
+			rdr.backlog = "none"
+			return nil
+		}
+		m := strings.ToLower(mode)
+		switch m {
+		case "all", "unprocessed", "none":
+			rdr.backlog = m
+			return nil
+		}
+		return errors.New("otf-reader Backlog " + mode + " not supported (must be one of all|unprocessed|none)")
+	}
+}
+
+//
+// choose how the reader decides that a new or changed file has
+// been completely written before reading it, one of
+// stable: size and modification time unchanged for the given
+// number of watcher poll intervals (the default, 2 intervals)
+// marker: a ready marker file exists alongside the file, eg.
+// results.csv.done or results.done for markers ".done,.ready"
+// none: read as soon as the watcher reports the file
+//
+// files ending in one of the temp suffixes (eg. "part,tmp") are
+// never read, a file renamed from a temp name is read as new.
+//
+func Settle(mode string, intervals int, markers string, tempSuffixes string) Option {
+	return func(rdr *OtfReader) error {
+		m := strings.ToLower(mode)
+		switch m {
+		case "":
+			m = "stable"
+		case "stable", "marker", "none":
+		default:
+			return errors.New("otf-reader Settle mode " + mode + " not supported (must be one of stable|marker|none)")
+		}
+		if intervals < 0 {
+			return errors.New("otf-reader Settle intervals cannot be negative")
+		}
+		rdr.settleMode = m
+		if intervals > 0 {
+			rdr.settleChecks = intervals
+		}
+		rdr.readyMarkers = parseSuffixes(markers)
+		if m == "marker" && len(rdr.readyMarkers) == 0 {
+			return errors.New("otf-reader Settle markers must be provided for marker mode")
+		}
+		rdr.tempSuffixes = parseSuffixes(tempSuffixes)
+		return nil
+	}
+}
+
+//
+// publish only the records that have been added or changed
+// since a file was last read, for files that are re-exported
+// in full with a few new rows. records are matched on the
+// comma-separated key fields (gjson paths, eg. "sourcedId")
+// if given, otherwise on their whole content. with deletes
+// set, a "deleted" message (with the key fields as its
+// original block) is published for each record that has
+// gone from the file. needs a StateFolder.
+//
+func Delta(enabled bool, keyFields string, deletes bool) Option {
+	return func(rdr *OtfReader) error {
+		rdr.delta = enabled
+		rdr.deltaKeys = []string{}
+		for _, k := range strings.Split(keyFields, ",") {
+			if k = strings.TrimSpace(k); k != "" {
+				rdr.deltaKeys = append(rdr.deltaKeys, k)
+			}
+		}
+		rdr.deltaDeletes = deletes
+		return nil
+	}
+}
+
+//
+// choose what happens to files once they have been read, one of
+// move: files that were published are moved to the processed
+// folder (gzipped if gzip is set), and files that failed to the
+// failed folder, with an .error.txt file giving the error
+// delete: published files are deleted, and failed files moved
+// none: files are left where they are (the default)
+//
+// relative folders are within the watch folder, and default to
+// processed and failed. with datePartitions files are moved to a
+// yyyy/mm/dd folder below them.
+//
+func Disposition(mode string, processedFolder string, failedFolder string, datePartitions bool, gzip bool) Option {
+	return func(rdr *OtfReader) error {
+		m := strings.ToLower(mode)
+		switch m {
+		case "":
+			m = "none"
+		case "move", "delete", "none":
+		default:
+			return errors.New("otf-reader Disposition " + mode + " not supported (must be one of move|delete|none)")
+		}
+		rdr.disposition = m
+		rdr.processedDir = processedFolder
+		if rdr.processedDir == "" {
+			rdr.processedDir = "processed"
+		}
+		rdr.failedDir = failedFolder
+		if rdr.failedDir == "" {
+			rdr.failedDir = "failed"
+		}
+		rdr.datePartition = datePartitions
+		rdr.gzipProcessed = gzip
+		return nil
+	}
+}
+
 //
 // set the number of input files that can be handled concurrently
 // set if number of filehandles on OS is a problem
@@ -235,6 +753,27 @@ func ConcurrentFiles(n int) Option {
 
 }
 
+//
+// limit the number of published messages waiting to be
+// acknowledged, for each file (default 1000) and in total
+// across all files being read (default 10000); reading
+// waits until there is room for another message.
+//
+func MaxInFlight(perFile int, total int) Option {
+	return func(rdr *OtfReader) error {
+		if perFile < 0 || total < 0 {
+			return errors.New("otf-reader MaxInFlight limits cannot be negative")
+		}
+		if perFile > 0 {
+			rdr.fileInFlight = perFile
+		}
+		if total > 0 {
+			rdr.maxInFlight = total
+		}
+		return nil
+	}
+}
+
 //
 // configure the internal file watcher
 //
@@ -273,10 +812,12 @@ func Watcher(folder string, fileSuffix string, interval string, recursive bool,
 		rdr.ignore = ignore
 
 		// Only files that match the regular expression for file suffix during file listings
-		// will be watched.
+		// will be watched. Archives are also watched, and their members are
+		// filtered by the same suffix when they are read.
 		if fileSuffix != "" {
 			trimSuffix := strings.Trim(fileSuffix, ".")
-			r := regexp.MustCompile("([^\\s]+(\\.(?i)(" + trimSuffix + "))$)")
+			rdr.suffixRegex = regexp.MustCompile("([^\\s]+(\\.(?i)(" + trimSuffix + "))$)")
+			r := regexp.MustCompile("([^\\s]+(\\.(?i)(" + trimSuffix + "|" + strings.Join(archiveSuffixes, "|") + "))$)")
 			rdr.watcher.AddFilterHook(watcher.RegexFilterHook(r, false))
 		}
 		rdr.watchFileSuffix = fileSuffix
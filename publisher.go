@@ -0,0 +1,369 @@
+package otfreader
+
+import (
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	stan "github.com/nats-io/stan.go"
+	"github.com/nsip/otf-reader/internal/util"
+	"github.com/pkg/errors"
+	"github.com/tidwall/gjson"
+)
+
+//
+// a Publisher sends otf messages on to the next stage of the
+// otf workflow. the reader uses nats streaming by default, but
+// any publisher can be supplied with the MessagePublisher option,
+// for example to embed the reader in another pipeline, or to
+// capture messages in memory for testing.
+//
+type Publisher interface {
+	// sends the message to the topic asynchronously, returning an id for
+	// the message. the ack handler (if not nil) is called once with the
+	// outcome when the publish has been acknowledged or has failed.
+	// the outcome is reported one way only: if Publish returns an
+	// error the ack handler is never called, otherwise it is always
+	// called exactly once (possibly before Publish returns).
+	Publish(topic string, msg []byte, ack AckHandler) (string, error)
+	// blocks until all messages published so far have been acknowledged
+	Flush() error
+	// releases the publisher's connection
+	Close() error
+}
+
+//
+// receives the outcome of an asynchronous publish,
+// err is nil if the message was accepted
+//
+type AckHandler func(msgID string, err error)
+
+//
+// creates the configured publisher, connecting to the
+// nats streaming server (the default) or jetstream,
+// opening the output file, or posting to a url
+//
+func (rdr *OtfReader) connectPublisher() (Publisher, error) {
+	switch rdr.publisherType {
+	case "jetstream":
+		topics := append([]string{rdr.publishTopic}, rdr.otherTopics()...)
+		subjects := jetStreamSubjects(rdr.jsSubjects, rdr.publishTopic, rdr.otherTopics())
+		return newJetStreamPublisher(rdr.natsHost, rdr.name, rdr.natsPort, rdr.jsStream, subjects, topics, rdr.jsMaxPending)
+	case "file":
+		return newFileSinkPublisher(rdr.outFile, rdr.outRotateMB, rdr.outTopics)
+	case "http":
+		return newHTTPPublisher(rdr.httpURL, rdr.httpHeader, rdr.httpToken, rdr.httpBatchSize, rdr.httpConcurrency, rdr.httpRetries, rdr.httpTimeout)
+	default:
+		return newStanPublisher(rdr.natsHost, rdr.natsCluster, rdr.name, rdr.natsPort)
+	}
+}
+
+//
+// topics other than the reader topic that messages are published
+// to: dead letters, control messages, file events and the default
+// topic for routing (routed topics are only known as messages
+// are published)
+//
+func (rdr *OtfReader) otherTopics() []string {
+	topics := []string{}
+	add := func(t string) {
+		if t != "" && t != rdr.publishTopic {
+			topics = append(topics, t)
+		}
+	}
+	add(rdr.deadLetterTopic)
+	if rdr.controlMsgs {
+		add(rdr.controlTopic)
+	}
+	add(rdr.fileEventTopic)
+	if rdr.router != nil {
+		add(rdr.router.defaultTopic)
+	}
+	return topics
+}
+
+//
+// first wait between attempts to reconnect to the nats
+// streaming server, doubled on each attempt up to the maximum
+//
+const (
+	stanReconnectWait    = time.Second
+	stanMaxReconnectWait = 30 * time.Second
+)
+
+//
+// publishes to a nats streaming server.
+// if the connection is lost, publishing is paused (so file
+// processing waits) while the publisher reconnects with backoff,
+// then any messages that were not acknowledged are published again.
+//
+type stanPublisher struct {
+	host     string
+	cluster  string
+	client   string
+	port     int
+	mu       sync.Mutex
+	resumed  *sync.Cond // signalled when publishing can carry on
+	sc       stan.Conn
+	up       bool // connected
+	paused   bool // waiting to reconnect, or re-publishing
+	closed   bool
+	pending  map[*stanMsg]struct{}
+	seq      uint64
+	inFlight sync.WaitGroup
+}
+
+//
+// a message waiting for its ack, seq is the order it was
+// published in, and attempt counts the times it has been
+// published so that acks from before a reconnect are ignored
+//
+type stanMsg struct {
+	seq     uint64
+	id      string
+	topic   string
+	msg     []byte
+	ack     AckHandler
+	attempt int
+}
+
+//
+// connects to the nats streaming server to create the
+// default publisher for the reader
+//
+func newStanPublisher(host, cluster, client string, port int) (*stanPublisher, error) {
+	sp := &stanPublisher{
+		host:    host,
+		cluster: cluster,
+		client:  client,
+		port:    port,
+		pending: map[*stanMsg]struct{}{},
+	}
+	sp.resumed = sync.NewCond(&sp.mu)
+
+	sc, err := util.NewConnection(host, cluster, client, port, sp.connectionLost)
+	if err != nil {
+		return nil, err
+	}
+	sp.sc = sc
+	sp.up = true
+	return sp, nil
+}
+
+//
+// publishes the message, waiting first if the
+// connection is being re-established
+//
+func (sp *stanPublisher) Publish(topic string, msg []byte, ack AckHandler) (string, error) {
+
+	id := gjson.GetBytes(msg, "meta.messageID").String()
+	if id == "" {
+		id = util.GenerateID()
+	}
+
+	sp.mu.Lock()
+	for sp.paused && !sp.closed {
+		sp.resumed.Wait()
+	}
+	closed := sp.closed
+	sp.seq++
+	seq := sp.seq
+	sp.mu.Unlock()
+	if closed {
+		return id, errors.New("publisher is closed")
+	}
+
+	sp.inFlight.Add(1)
+	return id, sp.send(&stanMsg{seq: seq, id: id, topic: topic, msg: msg, ack: ack})
+}
+
+//
+// publishes (or re-publishes) a message, returning an error
+// only if it has failed, rather than waiting to be re-published
+//
+func (sp *stanPublisher) send(sm *stanMsg) error {
+
+	sp.mu.Lock()
+	sc := sp.sc
+	sm.attempt++
+	attempt := sm.attempt
+	sp.pending[sm] = struct{}{}
+	sp.mu.Unlock()
+
+	_, err := sc.PublishAsync(sm.topic, sm.msg, func(_ string, err error) {
+		if sp.settle(sm, attempt, sc, err) && sm.ack != nil {
+			sm.ack(sm.id, err)
+		}
+	})
+	if err != nil && sp.settle(sm, attempt, sc, err) {
+		return err
+	}
+	return nil
+}
+
+//
+// re-publishes a message, passing any failure to its ack handler
+//
+func (sp *stanPublisher) resend(sm *stanMsg) {
+	if err := sp.send(sm); err != nil && sm.ack != nil {
+		sm.ack(sm.id, err)
+	}
+}
+
+//
+// records the outcome of a publish, returning false if it
+// should be ignored: either the message has been published
+// again since, or it failed because the connection is down
+// and will be re-published once reconnected
+//
+func (sp *stanPublisher) settle(sm *stanMsg, attempt int, sc stan.Conn, err error) bool {
+
+	sp.mu.Lock()
+	if _, ok := sp.pending[sm]; !ok || attempt != sm.attempt {
+		sp.mu.Unlock()
+		return false
+	}
+	if err != nil && !sp.closed && (!sp.up || connectionDown(sc, err)) {
+		sp.mu.Unlock()
+		sp.connectionLost(sc, err)
+		return false
+	}
+	delete(sp.pending, sm)
+	sp.mu.Unlock()
+
+	sp.inFlight.Done()
+	return true
+}
+
+//
+// true if the publish failed because the connection is down, which
+// can be some time before the streaming client reports it as lost
+//
+func connectionDown(sc stan.Conn, err error) bool {
+	switch err {
+	case stan.ErrConnectionClosed, nats.ErrConnectionClosed, nats.ErrConnectionReconnecting, nats.ErrReconnectBufExceeded:
+		return true
+	case stan.ErrTimeout:
+		return !sc.NatsConn().IsConnected()
+	}
+	return false
+}
+
+//
+// called when the connection is lost, either by the streaming
+// client or when a publish fails because the connection is down.
+// pauses publishing, closes the connection and starts reconnecting.
+//
+func (sp *stanPublisher) connectionLost(sc stan.Conn, reason error) {
+
+	sp.mu.Lock()
+	if sp.closed || !sp.up || sc != sp.sc {
+		// already reconnecting, or an old connection
+		sp.mu.Unlock()
+		return
+	}
+	sp.up = false
+	sp.paused = true
+	sp.mu.Unlock()
+
+	log.Printf("Warning: connection to streaming server lost: %v, publishing paused while reconnecting\n", reason)
+	go sc.Close()
+	go sp.reconnect(time.Now())
+}
+
+//
+// keeps trying to connect to the streaming server, waiting a
+// little longer after each failed attempt, then re-publishes
+// any messages not acknowledged before the connection was lost
+//
+func (sp *stanPublisher) reconnect(lost time.Time) {
+
+	wait := stanReconnectWait
+	var sc stan.Conn
+	for attempt := 1; ; attempt++ {
+		sp.mu.Lock()
+		closed := sp.closed
+		sp.mu.Unlock()
+		if closed {
+			return
+		}
+
+		var err error
+		sc, err = util.NewConnection(sp.host, sp.cluster, sp.client, sp.port, sp.connectionLost)
+		if err == nil {
+			log.Printf("Reconnected to streaming server after %d attempt(s), outage lasted %s\n", attempt, time.Since(lost).Truncate(time.Millisecond))
+			break
+		}
+		log.Printf("Warning: reconnect attempt %d to streaming server failed: %v, retrying in %s\n", attempt, err, wait)
+		time.Sleep(wait)
+		if wait *= 2; wait > stanMaxReconnectWait {
+			wait = stanMaxReconnectWait
+		}
+	}
+
+	sp.mu.Lock()
+	if sp.closed {
+		sp.mu.Unlock()
+		sc.Close()
+		return
+	}
+	sp.sc = sc
+	sp.up = true
+	unacked := make([]*stanMsg, 0, len(sp.pending))
+	for sm := range sp.pending {
+		unacked = append(unacked, sm)
+	}
+	sp.mu.Unlock()
+
+	// re-publish in the original order, before new messages
+	sort.Slice(unacked, func(i, j int) bool { return unacked[i].seq < unacked[j].seq })
+	if len(unacked) > 0 {
+		log.Printf("Re-publishing %d un-acknowledged message(s)\n", len(unacked))
+	}
+	for _, sm := range unacked {
+		sp.resend(sm)
+	}
+
+	sp.mu.Lock()
+	sp.paused = false
+	sp.resumed.Broadcast()
+	sp.mu.Unlock()
+}
+
+func (sp *stanPublisher) Flush() error {
+	sp.inFlight.Wait()
+	return nil
+}
+
+//
+// closes the connection, any messages waiting for the
+// connection to come back are failed
+//
+func (sp *stanPublisher) Close() error {
+
+	sp.mu.Lock()
+	sp.closed = true
+	sp.resumed.Broadcast()
+	up := sp.up
+	unacked := []*stanMsg{}
+	if !up {
+		for sm := range sp.pending {
+			unacked = append(unacked, sm)
+			delete(sp.pending, sm)
+		}
+	}
+	sp.mu.Unlock()
+
+	for _, sm := range unacked {
+		if sm.ack != nil {
+			sm.ack(sm.id, stan.ErrConnectionClosed)
+		}
+		sp.inFlight.Done()
+	}
+	if !up {
+		return nil
+	}
+	return sp.sc.Close()
+}
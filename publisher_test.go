@@ -0,0 +1,240 @@
+package otfreader
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nsip/otf-reader/internal/util"
+)
+
+//
+// an in-memory publisher that keeps every message published,
+// the outcome of each publish is decided by ackErr (passed to
+// the ack handler) and publishErr (returned from Publish)
+//
+type memPublisher struct {
+	mu         sync.Mutex
+	msgs       []memMessage
+	ackErr     func(topic string, msg []byte) error
+	publishErr func(topic string, msg []byte) error
+}
+
+type memMessage struct {
+	topic string
+	msg   []byte
+}
+
+func (mp *memPublisher) Publish(topic string, msg []byte, ack AckHandler) (string, error) {
+
+	id := util.GenerateID()
+	if mp.publishErr != nil {
+		if err := mp.publishErr(topic, msg); err != nil {
+			return id, err
+		}
+	}
+
+	mp.mu.Lock()
+	mp.msgs = append(mp.msgs, memMessage{topic: topic, msg: msg})
+	mp.mu.Unlock()
+
+	var err error
+	if mp.ackErr != nil {
+		err = mp.ackErr(topic, msg)
+	}
+	if ack != nil {
+		// acks arrive asynchronously, as from a broker
+		go ack(id, err)
+	}
+	return id, nil
+}
+
+func (mp *memPublisher) Flush() error { return nil }
+
+func (mp *memPublisher) Close() error { return nil }
+
+//
+// the messages published to the topic
+//
+func (mp *memPublisher) topic(topic string) [][]byte {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+	msgs := [][]byte{}
+	for _, m := range mp.msgs {
+		if m.topic == topic {
+			msgs = append(msgs, m.msg)
+		}
+	}
+	return msgs
+}
+
+const testCSV = "id,name\n1,Ada\n2,bad\n3,Grace\n"
+
+//
+// creates a reader publishing csv to the publisher, with control
+// messages (which carry the counts for the file) on otf.control
+//
+func newTestReader(t *testing.T, p Publisher) *OtfReader {
+	rdr, err := New(
+		Name("test"),
+		ID("test"),
+		InputFormat("csv"),
+		TopicName("otf.ingest"),
+		ControlMessages(true, "otf.control"),
+		MessagePublisher(p),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rdr
+}
+
+func writeTestFile(t *testing.T, name string, content string) string {
+	dir, err := ioutil.TempDir("", "otf-reader")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+//
+// publishes the file, failing the test if it does not finish
+//
+func publishTestFile(t *testing.T, rdr *OtfReader, path string) error {
+	done := make(chan error, 1)
+	go func() { done <- rdr.publishFile(path, false) }()
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(10 * time.Second):
+		t.Fatal("publishFile did not finish")
+	}
+	return nil
+}
+
+//
+// the counts in the fileComplete control message
+//
+func completeCounts(t *testing.T, p *memPublisher) fileCounts {
+	for _, m := range p.topic("otf.control") {
+		var cm controlMessage
+		if err := json.Unmarshal(m, &cm); err != nil {
+			t.Fatal(err)
+		}
+		if cm.Control == controlFileComplete {
+			return *cm.Counts
+		}
+	}
+	t.Fatal("no fileComplete message")
+	return fileCounts{}
+}
+
+func TestPublishFileCounts(t *testing.T) {
+
+	cases := []struct {
+		name       string
+		ackErr     func(string, []byte) error
+		publishErr func(string, []byte) error
+		published  int64
+		failed     int64
+	}{
+		{name: "all acked", published: 3},
+		{
+			name: "failed acks",
+			ackErr: func(topic string, msg []byte) error {
+				if strings.Contains(string(msg), "bad") {
+					return errors.New("nack")
+				}
+				return nil
+			},
+			published: 2,
+			failed:    1,
+		},
+		{
+			name: "publish errors",
+			publishErr: func(topic string, msg []byte) error {
+				if topic == "otf.ingest" {
+					return errors.New("not connected")
+				}
+				return nil
+			},
+			failed: 3,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			p := &memPublisher{ackErr: c.ackErr, publishErr: c.publishErr}
+			rdr := newTestReader(t, p)
+			err := publishTestFile(t, rdr, writeTestFile(t, "results.csv", testCSV))
+
+			if (err != nil) != (c.failed > 0) {
+				t.Errorf("publishFile returned %v, with %d failed", err, c.failed)
+			}
+			counts := completeCounts(t, p)
+			if counts.Published != c.published || counts.Failed != c.failed {
+				t.Errorf("%d published, %d failed, expected %d and %d", counts.Published, counts.Failed, c.published, c.failed)
+			}
+			if n := len(p.topic("otf.ingest")); n != int(c.published+c.failed) && c.publishErr == nil {
+				t.Errorf("%d messages published, expected %d", n, c.published+c.failed)
+			}
+		})
+	}
+}
+
+func TestPublishFileClosedSink(t *testing.T) {
+
+	// a sink that fails every write, each record
+	// should be counted as failed once
+	sink, err := newFileSinkPublisher(filepath.Join(os.TempDir(), "otf-reader-closed-sink.ndjson"), 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink.Close()
+	os.Remove(sink.path)
+
+	rdr := newTestReader(t, sink)
+	err = publishTestFile(t, rdr, writeTestFile(t, "results.csv", testCSV))
+	if err == nil || !strings.Contains(err.Error(), "3 records") {
+		t.Errorf("publishFile returned %v, expected 3 records to fail", err)
+	}
+}
+
+func TestPublishFileMessages(t *testing.T) {
+
+	p := &memPublisher{}
+	rdr := newTestReader(t, p)
+	path := writeTestFile(t, "results.csv", testCSV)
+	if err := publishTestFile(t, rdr, path); err != nil {
+		t.Fatal(err)
+	}
+
+	msgs := p.topic("otf.ingest")
+	if len(msgs) != 3 {
+		t.Fatalf("%d messages, expected 3", len(msgs))
+	}
+	var m struct {
+		Meta     map[string]interface{} `json:"meta"`
+		Original map[string]interface{} `json:"original"`
+	}
+	if err := json.Unmarshal(msgs[0], &m); err != nil {
+		t.Fatal(err)
+	}
+	if m.Original["name"] != "Ada" {
+		t.Errorf("original is %v", m.Original)
+	}
+	if m.Meta["sourceFileName"] != path || m.Meta["readerName"] != "test" {
+		t.Errorf("meta is %v", m.Meta)
+	}
+}
@@ -1,16 +1,21 @@
 package otfreader
 
 import (
+	"bufio"
 	"encoding/json"
 	"fmt"
 	"io"
 	"log"
+	"net/http"
 	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
-	"github.com/cdutwhu/n3-util/n3csv"
-	stan "github.com/nats-io/stan.go"
 	"github.com/nsip/otf-reader/internal/util"
 	"github.com/pkg/errors"
 	"github.com/radovskyb/watcher"
@@ -36,8 +41,79 @@ type OtfReader struct {
 	dotfiles        bool
 	ignore          string
 	watcher         *watcher.Watcher
-	sc              stan.Conn
+	publisher       Publisher
+	publisherType   string
+	jsStream        string
+	jsSubjects      string
+	jsMaxPending    int
+	outFile         string
+	outRotateMB     int
+	outTopics       bool
+	httpURL         string
+	httpHeader      http.Header
+	httpToken       string
+	httpBatchSize   int
+	httpConcurrency int
+	httpRetries     int
+	httpTimeout     time.Duration
 	concurrentFiles int
+	fileInFlight    int
+	maxInFlight     int
+	inFlight        chan struct{}
+	xmlRecordPath   string
+	selector        *recordSelector
+	selectorExpr    string
+	carryFields     string
+	csvDialect      csvDialect
+	encoding        string
+	xlsxSheet       string
+	xlsxHeaderRow   int
+	columnTypes     *columnTypes
+	suffixRegex     *regexp.Regexp
+	router          *topicRouter
+	topicRoutes     string
+	deadLetterTopic string
+	deadLetterDir   string
+	deadLetters     *deadLetterQueue
+	controlMsgs     bool
+	controlTopic    string
+	fileEventTopic  string
+	batches         *batchIndex
+	stateDir        string
+	ledger          *ledger
+	backlog         string
+	settleMode      string
+	settleChecks    int
+	readyMarkers    []string
+	tempSuffixes    []string
+	settling        *pathSet
+	workers         chan struct{}
+	disposition     string
+	processedDir    string
+	failedDir       string
+	datePartition   bool
+	gzipProcessed   bool
+	disposed        *pathSet
+	delta           bool
+	deltaKeys       []string
+	deltaDeletes    bool
+	out             io.Writer
+}
+
+//
+// called by the input format readers with the json
+// for each record found in the input file, along with
+// any format specific meta-data for the record
+//
+type recordHandler func(original []byte, meta ...metaField) error
+
+//
+// an additional value to add to the meta-data block
+// of an otf message, such as the row number of a record
+//
+type metaField struct {
+	name  string
+	value interface{}
 }
 
 //
@@ -45,21 +121,95 @@ type OtfReader struct {
 //
 func New(options ...Option) (*OtfReader, error) {
 
-	rdr := OtfReader{}
+	rdr := OtfReader{
+		csvDialect:      defaultCSVDialect,
+		encoding:        "auto",
+		xlsxHeaderRow:   1,
+		publisherType:   "stan",
+		jsStream:        "OTF",
+		jsMaxPending:    4000,
+		httpBatchSize:   1,
+		httpConcurrency: 4,
+		httpRetries:     5,
+		httpTimeout:     30 * time.Second,
+		fileInFlight:    1000,
+		maxInFlight:     10000,
+		batches:         newBatchIndex(),
+		deadLetters:     newDeadLetterQueue(),
+		backlog:         "none",
+		settleMode:      "stable",
+		settleChecks:    2,
+		readyMarkers:    []string{".done", ".ready"},
+		tempSuffixes:    []string{".part", ".tmp"},
+		settling:        newPathSet(),
+		disposition:     "none",
+		disposed:        newPathSet(),
+	}
 
 	if err := rdr.setOptions(options...); err != nil {
 		return nil, err
 	}
 
+	if rdr.inputFormat == "xml" && rdr.xmlRecordPath == "" {
+		return nil, errors.New("otf-reader XMLRecordPath must be provided for xml input.")
+	}
+
+	rdr.inFlight = make(chan struct{}, rdr.maxInFlight)
+
+	// progress and config are printed to stdout, unless the
+	// otf messages are being written there
+	rdr.out = os.Stdout
+	if rdr.publisherType == "file" && (rdr.outFile == "" || rdr.outFile == "-") {
+		rdr.out = os.Stderr
+	}
+
+	if rdr.publisherType == "http" && rdr.httpURL == "" {
+		return nil, errors.New("otf-reader HTTPSink url must be provided for the http publisher.")
+	}
+
+	if rdr.delta && rdr.stateDir == "" {
+		return nil, errors.New("otf-reader Delta needs a StateFolder to keep record fingerprints in.")
+	}
+
+	if err := rdr.setupDisposition(); err != nil {
+		return nil, err
+	}
+
 	return &rdr, nil
 }
 
+//
+// how long Close waits for published messages to be
+// acknowledged before closing the publisher anyway
+//
+const closeFlushWait = 30 * time.Second
+
 //
 // ensure graceful shutdown of file-watcher
 //
 func (rdr *OtfReader) Close() {
-	rdr.sc.Close()
-	rdr.watcher.Close()
+	if rdr.publisher != nil {
+		// dead letters for failed publishes are only queued
+		// once the publisher has reported the failure, so they
+		// are flushed after the messages that failed
+		flushed := make(chan struct{})
+		go func() {
+			rdr.publisher.Flush()
+			rdr.deadLetters.wait()
+			rdr.publisher.Flush()
+			close(flushed)
+		}()
+		select {
+		case <-flushed:
+		case <-time.After(closeFlushWait):
+			log.Println("Warning: closing publisher with messages still waiting to be acknowledged")
+		}
+		rdr.publisher.Close()
+	}
+	if rdr.watcher != nil {
+		rdr.watcher.Close()
+	}
+	rdr.ledger.close()
 }
 
 //
@@ -68,42 +218,61 @@ func (rdr *OtfReader) Close() {
 //
 func (rdr *OtfReader) StartWatcher() error {
 
-	// get a nats connection
-	var connErr error
-	rdr.sc, connErr = util.NewConnection(rdr.natsHost, rdr.natsCluster, rdr.name, rdr.natsPort)
-	if connErr != nil {
-		return connErr
+	// get a nats connection, unless a publisher has been provided
+	if rdr.publisher == nil {
+		p, connErr := rdr.connectPublisher()
+		if connErr != nil {
+			return connErr
+		}
+		rdr.publisher = p
 	}
 
+	// open the ledger of processed files, if there is a state folder
+	if rdr.stateDir != "" && rdr.ledger == nil {
+		l, err := openLedger(rdr.stateDir)
+		if err != nil {
+			return err
+		}
+		rdr.ledger = l
+	}
+
+	// set up worker pool semaphore, to prevent hitting file-handle limits
+	rdr.workers = make(chan struct{}, rdr.concurrentFiles)
+
 	// main watcher event processing loop
 	go func() {
 
-		// set up worker pool semaphore, to prevent hitting file-handle limits
-		type token struct{}
-		sem := make(chan token, rdr.concurrentFiles)
-
+	loop:
 		for {
 			select {
 			case event := <-rdr.watcher.Event:
-				if event.Op == watcher.Remove {
-					fmt.Printf("\nfile: %s\noperation: %s\nmodified: %s\n", event.Path, event.Op, time.Now())
-				} else if (event.Op == watcher.Write || event.Op == watcher.Create) && event.IsDir() == false {
-					fmt.Printf("\nfile: %s\noperation: %s\nmodified: %s\n", event.Path, event.Op, event.ModTime())
-					sem <- token{}             // acquire pool slot
-					go func(fileName string) { // spawn publishing worker
-						err := rdr.publishFile(fileName)
-						if err != nil {
-							log.Println("error publishing file: ", fileName, err)
-						}
-						<-sem // release slot back to pool
-					}(event.Path)
+				if rdr.renamedFromTemp(event) && event.IsDir() == false {
+					// upload complete, read as a new file
+					fmt.Fprintf(rdr.out, "\nfile: %s\noperation: %s\nfrom: %s\n", event.Path, event.Op, event.OldPath)
+					rdr.publishWhenSettled(event.Path)
+				} else if event.Op == watcher.Remove && event.IsDir() == false && (rdr.disposed.remove(event.Path) || rdr.ignoredFile(event.Path)) {
+					// moved or deleted by the reader once read,
+					// or a temp file or ready marker
+					continue
+				} else if event.Op == watcher.Remove && event.IsDir() == false {
+					fmt.Fprintf(rdr.out, "\nfile: %s\noperation: %s\nmodified: %s\n", event.Path, event.Op, time.Now())
+					rdr.publishFileEvent(event)
+				} else if (event.Op == watcher.Rename || event.Op == watcher.Move) && event.IsDir() == false {
+					fmt.Fprintf(rdr.out, "\nfile: %s\noperation: %s\nfrom: %s\n", event.Path, event.Op, event.OldPath)
+					rdr.publishFileEvent(event)
+				} else if (event.Op == watcher.Write || event.Op == watcher.Create) && event.IsDir() == false && !rdr.ignoredFile(event.Path) {
+					fmt.Fprintf(rdr.out, "\nfile: %s\noperation: %s\nmodified: %s\n", event.Path, event.Op, event.ModTime())
+					rdr.disposed.remove(event.Path)
+					// published by a worker once the file
+					// has been completely written
+					rdr.publishWhenSettled(event.Path)
 				}
 			case err := <-rdr.watcher.Error:
-				fmt.Println("\tFile-watcher error occurred: ", err)
-				fmt.Println("File-watching suspended, recommend reader restart.")
-				return
+				fmt.Fprintln(rdr.out, "\tFile-watcher error occurred: ", err)
+				fmt.Fprintln(rdr.out, "File-watching suspended, recommend reader restart.")
+				break loop
 			case <-rdr.watcher.Closed:
-				return
+				break loop
 			}
 
 		}
@@ -111,11 +280,14 @@ func (rdr *OtfReader) StartWatcher() error {
 		// blocks until buffered channel can be filled to limit -
 		// only possible once all workers have released back to pool
 		for n := rdr.concurrentFiles; n > 0; n-- {
-			sem <- token{}
+			rdr.workers <- struct{}{}
 		}
 
 	}()
 
+	// publish any files that arrived while the reader was down
+	rdr.publishBacklog()
+
 	// Start the watching process.
 	if err := rdr.watcher.Start(rdr.interval); err != nil {
 		return err
@@ -129,85 +301,334 @@ func (rdr *OtfReader) StartWatcher() error {
 // then streaming otf format json records to nats.
 // otf records contain original data and meta-data blocks.
 //
-func (rdr *OtfReader) publishFile(fileName string) error {
+func (rdr *OtfReader) publishFile(fileName string, force bool) error {
 
-	fmt.Println("PUBLISHING:", fileName)
+	if rdr.bundleMember(fileName) {
+		fmt.Fprintf(rdr.out, "skipping %s, oneroster files are read from the bundle %s or zip file\n", fileName, oneRosterManifest)
+		return nil
+	}
+
+	fmt.Fprintln(rdr.out, "PUBLISHING:", fileName)
 	defer util.TimeTrack(time.Now(), "publishFile()")
 
-	var inputFile io.Reader
-	inputFile, err := os.Open(fileName)
+	f, err := os.Open(fileName)
 	if err != nil {
 		return err
 	}
+	defer f.Close()
 
-	if rdr.inputFormat == "csv" {
-		// if csv then first convert to json
-		json, _, err := n3csv.Reader2JSON(inputFile, "")
-		if err != nil {
-			return errors.Wrap(err, "error converting csv file to json")
-		}
-		// converter returns file as json string so re-wrap in reader
-		inputFile = strings.NewReader(json)
+	fi, err := f.Stat()
+	if err != nil {
+		return err
 	}
 
-	// now read json file as stream, publish each object to nats
-	d := json.NewDecoder(inputFile)
+	// every record from the file is tagged with the same batch
+	// id, for a bundle folder the hash covers its entity files
+	batch, err := newFileBatch(f, rdr.bundleFiles(fileName)...)
+	if err != nil {
+		return err
+	}
 
-	// read opening brace "["
-	_, err = d.Token()
+	// don't publish the same content again, unless forced
+	entry, found, err := rdr.ledger.get(fileName)
 	if err != nil {
-		return errors.Wrap(err, "unexpected token; json file should be json array")
+		log.Printf("Warning: unable to check ledger for %s: %v\n", fileName, err)
+	}
+	if !force && found && entry.Outcome == outcomePublished && entry.SHA256 == batch.hash {
+		fmt.Fprintf(rdr.out, "skipping %s, same content already published in batch %s\n", fileName, entry.BatchID)
+		f.Close()
+		rdr.removeMarkers(fileName)
+		rdr.dispose(fileName, batch, nil)
+		return nil
 	}
 
-	// for speed we're using async publishing in nats, which needs
-	// a callback handler for any publishing errors, which is set up here
-	ackHandler := func(ackedNuid string, err error) {
+	rdr.batches.set(fileName, batch)
+	rdr.publishControl(controlFileStart, fileName, batch, nil, nil)
+
+	// limits the messages from this file waiting to be
+	// acknowledged, as well as the total for the reader,
+	// so that reading waits for the publisher to catch up
+	fileSlots := make(chan struct{}, rdr.fileInFlight)
+	var pending sync.WaitGroup
+	var acked, failed int64
+
+	// in delta mode, only records added or changed since
+	// the file was last read are published
+	delta := rdr.newDeltaTracker(fileName, force)
+
+	// wraps a record into an otf message and publishes it
+	// to the topic. records that cannot be parsed or published
+	// go to the dead letters, and the rest of the file carries on.
+	rejected := 0
+	records := 0
+	publish := func(topic string, m []byte, meta []metaField) {
+
+		// the message id is made from the record's position, so
+		// it is the same if this content is read again
+		records++
+		msgID := batch.messageID(fileName, strconv.Itoa(records))
+		otfMsg, err := rdr.otfMessage(fileName, batch, msgID, m, meta)
 		if err != nil {
-			log.Printf("Warning: error publishing msg id %s: %v\n", ackedNuid, err.Error())
-		} else {
-			// log.Printf("Received ack for msg id %s\n", ackedNuid)
+			rejected++
+			rdr.deadLetter(fileName, "parse", topic, m, "", err, meta)
+			return
 		}
-	}
 
-	// read json objects one by one
-	objCount := 0
-	for d.More() {
-		var m json.RawMessage
-		err := d.Decode(&m)
+		// fmt.Printf("\n-------------\n%s\n-----------\n", otfMsg)
+
+		fileSlots <- struct{}{}
+		rdr.inFlight <- struct{}{}
+		pending.Add(1)
+		release := func() {
+			<-rdr.inFlight
+			<-fileSlots
+			pending.Done()
+		}
+
+		// publish to nats (or other publisher), on
+		// the topic picked by any routing templates.
+		// for speed we're using async publishing, so
+		// the outcome is reported to the ack handler
+		msgTopic := rdr.router.route(otfMsg, topic)
+		ackHandler := func(ackedNuid string, err error) {
+			if err != nil {
+				atomic.AddInt64(&failed, 1)
+				rdr.deadLetter(fileName, "publish", msgTopic, otfMsg, "", errors.Wrap(err, "msg id "+ackedNuid), meta)
+			} else {
+				atomic.AddInt64(&acked, 1)
+			}
+			release()
+		}
+		nuid, err := rdr.publisher.Publish(msgTopic, otfMsg, ackHandler)
 		if err != nil {
-			return errors.Wrap(err, "unable to decode json object.")
+			atomic.AddInt64(&failed, 1)
+			rdr.deadLetter(fileName, "publish", msgTopic, otfMsg, "", errors.Wrap(err, "msg id "+nuid), meta)
+			release()
+		}
+	}
+
+	// handles each record handed back by the format readers
+	publishTo := func(topic string) recordHandler {
+		return func(m []byte, meta ...metaField) error {
+			for _, mf := range meta {
+				if br, ok := mf.value.(*badRecord); ok {
+					rejected++
+					rdr.deadLetter(fileName, "parse", topic, m, br.offset, br.err, meta)
+					return nil
+				}
+			}
+			change, hash, publishable := delta.change(topic, m, meta)
+			if !publishable {
+				return nil
+			}
+			if change != "" {
+				meta = append(meta,
+					metaField{name: "deltaChange", value: change},
+					metaField{name: "recordHash", value: hash})
+			}
+			publish(topic, m, meta)
+			return nil
+		}
+	}
+
+	if rdr.inputFormat == "oneroster" {
+		// bundles publish each entity file to its own topic
+		err = rdr.readOneRoster(f, fileName, publishTo)
+	} else {
+		// archives and compressed files are unpacked, and
+		// each member is read using the input format
+		err = rdr.readArchive(f, fileName, "", false, publishTo(rdr.publishTopic))
+	}
+
+	// records that have gone from the file since it was last
+	// read, as long as the whole file could be read this time
+	deleted := 0
+	if err == nil && rdr.deltaDeletes {
+		for _, rp := range delta.deleted() {
+			original := []byte(rp.Key)
+			if original == nil {
+				original = []byte("{}")
+			}
+			publish(rp.Topic, original, []metaField{
+				{name: "deltaChange", value: deltaDeleted},
+				{name: "recordHash", value: rp.Hash},
+			})
+			deleted++
+		}
+	}
+
+	// the file is only done once every message has been
+	// acknowledged, or has failed
+	pending.Wait()
+
+	if rejected > 0 {
+		fmt.Fprintf(rdr.out, "%d records could not be read from %s\n", rejected, fileName)
+	}
+	fmt.Fprintf(rdr.out, "%d records published from %s, %d failed\n", acked, fileName, failed)
+	if err == nil && failed > 0 {
+		err = errors.Errorf("%d records could not be published", failed)
+	}
+
+	// fingerprints are only saved once every record has been
+	// published, so that any that failed are published next time
+	counts := &fileCounts{Published: acked, Failed: failed, Rejected: int64(rejected)}
+	if delta != nil {
+		fmt.Fprintf(rdr.out, "%d unchanged records skipped from %s, %d deleted\n", delta.unchanged, fileName, deleted)
+		counts.Unchanged = int64(delta.unchanged)
+		counts.Deleted = int64(deleted)
+		if err == nil {
+			if lerr := rdr.ledger.putRecords(fileName, delta.seen); lerr != nil {
+				log.Printf("Warning: unable to save record fingerprints for %s: %v\n", fileName, lerr)
+			}
 		}
-		// insert the read data into the standard otf message
-		otfMsg, err := sjson.SetRawBytes([]byte(""), "original", m)
+	}
+	rdr.publishControl(controlFileComplete, fileName, batch, counts, err)
+
+	entry = ledgerEntry{
+		Path:         fileName,
+		Size:         fi.Size(),
+		ModTime:      fi.ModTime().UTC(),
+		SHA256:       batch.hash,
+		BatchID:      batch.id,
+		Outcome:      outcomePublished,
+		Published:    acked,
+		Failed:       failed,
+		Rejected:     int64(rejected),
+		ProcessedUTC: time.Now().UTC(),
+	}
+	if err != nil {
+		entry.Outcome = outcomeFailed
+		entry.Error = err.Error()
+	}
+	if lerr := rdr.ledger.put(entry); lerr != nil {
+		log.Printf("Warning: unable to record %s in ledger: %v\n", fileName, lerr)
+	}
+
+	f.Close()
+	rdr.removeMarkers(fileName)
+	rdr.dispose(fileName, batch, err)
+
+	return err
+}
+
+//
+// builds the otf message for a record, with the record
+// as the original block and the reader and format
+// meta-data in the meta block
+//
+func (rdr *OtfReader) otfMessage(fileName string, batch fileBatch, msgID string, m []byte, meta []metaField) ([]byte, error) {
+
+	// insert the read data into the standard otf message
+	otfMsg, err := sjson.SetRawBytes([]byte(""), "original", m)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot add original json to otf message")
+	}
+	// now add the other meta-data
+	otfMsg, err = sjson.SetRawBytes(otfMsg, "meta", rdr.metaBytes(fileName, batch, msgID))
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot create meta-data block for otf message")
+	}
+	for _, mf := range meta {
+		otfMsg, err = sjson.SetBytes(otfMsg, "meta."+mf.name, mf.value)
 		if err != nil {
-			return errors.Wrap(err, "cannot add original json to otf message")
+			return nil, errors.Wrap(err, "cannot add "+mf.name+" to otf message meta-data")
 		}
-		// now add the other meta-data
-		otfMsg, err = sjson.SetRawBytes(otfMsg, "meta", rdr.metaBytes(fileName))
+	}
+	return otfMsg, nil
+}
+
+//
+// parses a (decompressed) input stream using the configured
+// input format, handing each record found to the handler
+//
+func (rdr *OtfReader) readInput(r io.Reader, fileName string, handler recordHandler) error {
+
+	// all text format readers work on utf-8 input
+	if rdr.inputFormat != "xlsx" {
+		var err error
+		r, err = decodeInput(r, rdr.encoding)
 		if err != nil {
-			return errors.Wrap(err, "cannot create meta-data block for otf message")
+			return errors.Wrap(err, "unable to decode input file")
 		}
+	}
 
-		// fmt.Printf("\n-------------\n%s\n-----------\n", otfMsg)
+	switch rdr.inputFormat {
+	case "xml":
+		return readXML(r, rdr.xmlRecordPath, handler)
+	case "ndjson":
+		return readNDJSON(r, fileName, rdr.selector, handler)
+	case "csv", "oneroster":
+		return readCSV(r, fileName, rdr.csvDialect, rdr.columnTypes, handler)
+	case "xlsx":
+		return readXLSX(r, fileName, rdr.xlsxSheet, rdr.xlsxHeaderRow, rdr.columnTypes, handler)
+	default:
+		return readJSON(r, rdr.selector, handler)
+	}
+}
 
-		// publish to nats
-		nuid, err := rdr.sc.PublishAsync(rdr.publishTopic, otfMsg, ackHandler)
+//
+// reads a json array as a stream, handing each object
+// to the record handler. if a record selector is given
+// it picks out the records from within each object instead.
+// members of the array that are not valid json are rejected
+// and reading carries on with the next member.
+//
+func readJSON(r io.Reader, sel *recordSelector, handler recordHandler) error {
+
+	// selector does not iterate a top-level array, so
+	// the whole document has to be read before selecting
+	if sel != nil && !sel.streamsArray() {
+		var doc json.RawMessage
+		if err := json.NewDecoder(r).Decode(&doc); err != nil {
+			return errors.Wrap(err, "unable to decode json document.")
+		}
+		return sel.selectRecords(doc, handler)
+	}
+
+	// read opening brace "["
+	as := &jsonArrayScanner{r: bufio.NewReader(r)}
+	if err := as.start(); err != nil {
+		return err
+	}
+
+	// read json objects one by one
+	for n := 1; ; n++ {
+		m, offset, err := as.next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return errors.Wrap(err, "unable to read json array")
+		}
+		if !json.Valid(m) {
+			err = rejectRecord(handler, m, fmt.Sprintf("array member %d at byte %d", n, offset), errors.New("invalid json"))
+		} else if sel != nil {
+			err = sel.selectFromMember(m, handler)
+		} else {
+			err = handler(m)
+		}
 		if err != nil {
-			log.Printf("Error publishing msg %s: %v\n", nuid, err.Error())
 			return err
 		}
-		objCount++
 	}
+}
 
-	fmt.Printf("%d records published from %s\n", objCount, fileName)
-	return nil
+//
+// true if the file name matches the watcher file suffix,
+// or if no suffix filter has been set
+//
+func (rdr *OtfReader) matchesSuffix(name string) bool {
+	if rdr.suffixRegex == nil {
+		return true
+	}
+	return rdr.suffixRegex.MatchString(filepath.Base(name))
 }
 
 //
 // constructs a json block containing values taken
 // from the reader, and the input file
 //
-func (rdr *OtfReader) metaBytes(fileName string) []byte {
+func (rdr *OtfReader) metaBytes(fileName string, batch fileBatch, msgID string) []byte {
 
 	metaString := fmt.Sprintf(`{
 	"providerName": "%s",
@@ -218,11 +639,13 @@ func (rdr *OtfReader) metaBytes(fileName string) []byte {
 	"readerID": "%s",
 	"capability": "%s",
 	"sourceFileName":"%s",
+	"sourceFileHash":"%s",
+	"batchID": "%s",
 	"messageID": "%s",
 	"readTimestampUTC":"%s"
 }`, rdr.providerName, rdr.inputFormat, rdr.alignMethod,
 		rdr.levelMethod, rdr.name, rdr.ID, rdr.genCapability,
-		fileName, util.GenerateID(),
+		fileName, batch.hash, batch.id, msgID,
 		time.Now().UTC().Format(time.RFC3339))
 
 	return []byte(metaString)
@@ -234,49 +657,170 @@ func (rdr *OtfReader) metaBytes(fileName string) []byte {
 //
 func (rdr *OtfReader) PrintConfig() {
 
-	fmt.Println("\n\tOTF-Reader Configuration")
-	fmt.Println("\t------------------------\n")
+	fmt.Fprintln(rdr.out, "\n\tOTF-Reader Configuration")
+	fmt.Fprintln(rdr.out, "\t------------------------")
+	fmt.Fprintln(rdr.out)
 
 	rdr.printID()
 	rdr.printDataConfig()
 	rdr.printNatsConfig()
+	rdr.printRoutingConfig()
+	rdr.printDeadLetterConfig()
+	rdr.printControlConfig()
+	if rdr.fileEventTopic != "" {
+		fmt.Fprintln(rdr.out, "\tfile event topic:\t", rdr.fileEventTopic)
+	}
+	if rdr.stateDir != "" {
+		fmt.Fprintln(rdr.out, "\tstate folder:\t\t", rdr.stateDir)
+	}
+	fmt.Fprintln(rdr.out, "\tbacklog:\t\t", rdr.backlog)
+	rdr.printDispositionConfig()
+	fmt.Fprintln(rdr.out, "\tdelta:\t\t\t", rdr.delta)
+	if rdr.delta {
+		fmt.Fprintln(rdr.out, "\tdelta key:\t\t", strings.Join(rdr.deltaKeys, ","))
+		fmt.Fprintln(rdr.out, "\tdelta deletes:\t\t", rdr.deltaDeletes)
+	}
 	rdr.printWatcherConfig()
 
 }
 
 func (rdr *OtfReader) printID() {
-	fmt.Println("\treader name:\t\t", rdr.name)
-	fmt.Println("\treader ID:\t\t", rdr.ID)
+	fmt.Fprintln(rdr.out, "\treader name:\t\t", rdr.name)
+	fmt.Fprintln(rdr.out, "\treader ID:\t\t", rdr.ID)
 }
 
 func (rdr *OtfReader) printDataConfig() {
-	fmt.Println("\tdata provider:\t\t", rdr.providerName)
-	fmt.Println("\tinput format:\t\t", rdr.inputFormat)
-	fmt.Println("\talign method:\t\t", rdr.alignMethod)
-	fmt.Println("\tlevel method:\t\t", rdr.levelMethod)
-	fmt.Println("\tgen-capability:\t\t", rdr.genCapability)
+	fmt.Fprintln(rdr.out, "\tdata provider:\t\t", rdr.providerName)
+	fmt.Fprintln(rdr.out, "\tinput format:\t\t", rdr.inputFormat)
+	fmt.Fprintln(rdr.out, "\tinput encoding:\t\t", rdr.encoding)
+	if rdr.inputFormat == "xml" {
+		fmt.Fprintln(rdr.out, "\txml record path:\t", rdr.xmlRecordPath)
+	}
+	if rdr.inputFormat == "csv" {
+		rdr.printCSVConfig()
+	}
+	if rdr.inputFormat == "xlsx" {
+		fmt.Fprintln(rdr.out, "\txlsx sheet:\t\t", rdr.xlsxSheet)
+		fmt.Fprintln(rdr.out, "\txlsx header row:\t", rdr.xlsxHeaderRow)
+	}
+	if rdr.columnTypes != nil {
+		fmt.Fprintln(rdr.out, "\tinfer value types:\t", rdr.columnTypes.infer)
+		fmt.Fprintln(rdr.out, "\tcolumn types:\t\t", rdr.columnTypes.schema)
+	}
+	if rdr.selector != nil {
+		fmt.Fprintln(rdr.out, "\trecord selector:\t", rdr.selectorExpr)
+		fmt.Fprintln(rdr.out, "\tcarry-down fields:\t", rdr.carryFields)
+	}
+	fmt.Fprintln(rdr.out, "\talign method:\t\t", rdr.alignMethod)
+	fmt.Fprintln(rdr.out, "\tlevel method:\t\t", rdr.levelMethod)
+	fmt.Fprintln(rdr.out, "\tgen-capability:\t\t", rdr.genCapability)
+}
+
+func (rdr *OtfReader) printCSVConfig() {
+	fmt.Fprintf(rdr.out, "\tcsv delimiter:\t\t %q\n", rdr.csvDialect.delimiter)
+	fmt.Fprintf(rdr.out, "\tcsv quote:\t\t %q\n", rdr.csvDialect.quote)
+	fmt.Fprintln(rdr.out, "\tcsv comment prefix:\t", rdr.csvDialect.comment)
+	fmt.Fprintln(rdr.out, "\tcsv skip lines:\t\t", rdr.csvDialect.skipLines)
+	if len(rdr.csvDialect.header) > 0 {
+		fmt.Fprintln(rdr.out, "\tcsv header:\t\t", strings.Join(rdr.csvDialect.header, ","))
+	}
+}
+
+func (rdr *OtfReader) printRoutingConfig() {
+	if rdr.router == nil {
+		return
+	}
+	fmt.Fprintln(rdr.out, "\ttopic routes:\t\t", rdr.topicRoutes)
+	fmt.Fprintln(rdr.out, "\tdefault topic:\t\t", rdr.router.route(nil, rdr.publishTopic))
+}
+
+func (rdr *OtfReader) printDeadLetterConfig() {
+	if rdr.deadLetterTopic != "" {
+		fmt.Fprintln(rdr.out, "\tdead letter topic:\t", rdr.deadLetterTopic)
+	} else if rdr.deadLetterDir != "" {
+		fmt.Fprintln(rdr.out, "\tdead letter folder:\t", rdr.deadLetterDir)
+	}
+}
+
+func (rdr *OtfReader) printControlConfig() {
+	fmt.Fprintln(rdr.out, "\tcontrol messages:\t", rdr.controlMsgs)
+	if rdr.controlMsgs && rdr.controlTopic != "" {
+		fmt.Fprintln(rdr.out, "\tcontrol topic:\t\t", rdr.controlTopic)
+	}
+}
+
+func (rdr *OtfReader) printDispositionConfig() {
+	fmt.Fprintln(rdr.out, "\tdisposition:\t\t", rdr.disposition)
+	if rdr.disposition == "none" {
+		return
+	}
+	if rdr.disposition == "move" {
+		fmt.Fprintln(rdr.out, "\tprocessed folder:\t", rdr.processedDir)
+		fmt.Fprintln(rdr.out, "\tgzip processed:\t\t", rdr.gzipProcessed)
+	}
+	fmt.Fprintln(rdr.out, "\tfailed folder:\t\t", rdr.failedDir)
+	fmt.Fprintln(rdr.out, "\tdate partitions:\t", rdr.datePartition)
 }
 
 func (rdr *OtfReader) printNatsConfig() {
-	fmt.Println("\tnats port:\t\t", rdr.natsPort)
-	fmt.Println("\tnats host:\t\t", rdr.natsHost)
-	fmt.Println("\tnats cluster-id:\t", rdr.natsCluster)
-	fmt.Println("\tnats topic:\t\t", rdr.publishTopic)
+	fmt.Fprintln(rdr.out, "\tpublisher:\t\t", rdr.publisherType)
+	if rdr.publisherType == "file" {
+		fmt.Fprintln(rdr.out, "\toutput file:\t\t", rdr.outFile)
+		fmt.Fprintln(rdr.out, "\toutput rotate (MB):\t", rdr.outRotateMB)
+		fmt.Fprintln(rdr.out, "\toutput topics:\t\t", rdr.outTopics)
+		fmt.Fprintln(rdr.out, "\ttopic:\t\t\t", rdr.publishTopic)
+		return
+	}
+	if rdr.publisherType == "http" {
+		fmt.Fprintln(rdr.out, "\thttp url:\t\t", rdr.httpURL)
+		headers := []string{}
+		for k := range rdr.httpHeader {
+			headers = append(headers, k)
+		}
+		fmt.Fprintln(rdr.out, "\thttp headers:\t\t", strings.Join(headers, ","))
+		fmt.Fprintln(rdr.out, "\thttp bearer token:\t", rdr.httpToken != "")
+		fmt.Fprintln(rdr.out, "\thttp batch size:\t", rdr.httpBatchSize)
+		fmt.Fprintln(rdr.out, "\thttp concurrency:\t", rdr.httpConcurrency)
+		fmt.Fprintln(rdr.out, "\thttp retries:\t\t", rdr.httpRetries)
+		fmt.Fprintln(rdr.out, "\thttp timeout:\t\t", rdr.httpTimeout)
+		fmt.Fprintln(rdr.out, "\ttopic:\t\t\t", rdr.publishTopic)
+		return
+	}
+	fmt.Fprintln(rdr.out, "\tnats port:\t\t", rdr.natsPort)
+	fmt.Fprintln(rdr.out, "\tnats host:\t\t", rdr.natsHost)
+	if rdr.publisherType == "jetstream" {
+		fmt.Fprintln(rdr.out, "\tjetstream stream:\t", rdr.jsStream)
+		fmt.Fprintln(rdr.out, "\tjetstream subjects:\t", strings.Join(jetStreamSubjects(rdr.jsSubjects, rdr.publishTopic, rdr.otherTopics()), ","))
+		fmt.Fprintln(rdr.out, "\tjetstream max pending:\t", rdr.jsMaxPending)
+	} else {
+		fmt.Fprintln(rdr.out, "\tnats cluster-id:\t", rdr.natsCluster)
+	}
+	fmt.Fprintln(rdr.out, "\tnats topic:\t\t", rdr.publishTopic)
 }
 
 func (rdr *OtfReader) printWatcherConfig() {
-	fmt.Println("\twatch file suffix:\t", rdr.watchFileSuffix)
-	fmt.Println("\twatch poll interval:\t", rdr.interval)
-	fmt.Println("\twatch dot files:\t", rdr.dotfiles)
-	fmt.Println("\tignore files:\t\t", rdr.ignore)
-	fmt.Println("\twatch folder:\t\t", rdr.watchFolder)
-	fmt.Println("\tmax concurrent files:\t\t", rdr.concurrentFiles)
-	fmt.Println("\tfiles being watched:")
+	fmt.Fprintln(rdr.out, "\twatch file suffix:\t", rdr.watchFileSuffix)
+	fmt.Fprintln(rdr.out, "\twatch poll interval:\t", rdr.interval)
+	fmt.Fprintln(rdr.out, "\twatch dot files:\t", rdr.dotfiles)
+	fmt.Fprintln(rdr.out, "\tignore files:\t\t", rdr.ignore)
+	fmt.Fprintln(rdr.out, "\twatch folder:\t\t", rdr.watchFolder)
+	fmt.Fprintln(rdr.out, "\tsettle mode:\t\t", rdr.settleMode)
+	if rdr.settleMode == "stable" {
+		fmt.Fprintln(rdr.out, "\tsettle intervals:\t", rdr.settleChecks)
+	}
+	if rdr.settleMode == "marker" {
+		fmt.Fprintln(rdr.out, "\tready markers:\t\t", strings.Join(rdr.readyMarkers, ","))
+	}
+	fmt.Fprintln(rdr.out, "\ttemp suffixes:\t\t", strings.Join(rdr.tempSuffixes, ","))
+	fmt.Fprintln(rdr.out, "\tmax concurrent files:\t\t", rdr.concurrentFiles)
+	fmt.Fprintln(rdr.out, "\tmax in-flight per file:\t\t", rdr.fileInFlight)
+	fmt.Fprintln(rdr.out, "\tmax in-flight messages:\t\t", rdr.maxInFlight)
+	fmt.Fprintln(rdr.out, "\tfiles being watched:")
 	for path, f := range rdr.watcher.WatchedFiles() {
 		// fmt.Printf("\t   %s: %s\n", path, f.Name())
 		_ = path
-		fmt.Printf("\t\t\t%s\n", f.Name())
+		fmt.Fprintf(rdr.out, "\t\t\t%s\n", f.Name())
 	}
-	fmt.Println()
+	fmt.Fprintln(rdr.out)
 
 }
@@ -0,0 +1,160 @@
+package otfreader
+
+import (
+	"log"
+	"strings"
+
+	"github.com/nsip/otf-reader/internal/util"
+	"github.com/pkg/errors"
+	"github.com/tidwall/gjson"
+)
+
+//
+// picks the topic for each otf message from values in the
+// message, using templates such as
+// otf.ingest.{{meta.capability}}.{{original.school.sector}}
+//
+// templates are tried in order, the first one where every
+// field is present and the rendered topic is valid is used,
+// otherwise the message goes to the default topic.
+//
+type topicRouter struct {
+	templates    []topicTemplate
+	defaultTopic string // empty to use the reader topic
+}
+
+//
+// a parsed template, alternating literal text and
+// gjson paths of fields in the otf message
+//
+type topicTemplate struct {
+	text  string
+	parts []templatePart
+}
+
+type templatePart struct {
+	literal string
+	path    string
+}
+
+//
+// parses a comma-separated list of topic templates, returns
+// nil if there are none (so every message goes to the reader topic)
+//
+func parseTopicRouter(routes string, defaultTopic string) (*topicRouter, error) {
+
+	tr := &topicRouter{}
+	for _, r := range strings.Split(routes, ",") {
+		if r = strings.TrimSpace(r); r == "" {
+			continue
+		}
+		tt, err := parseTopicTemplate(r)
+		if err != nil {
+			return nil, err
+		}
+		tr.templates = append(tr.templates, tt)
+	}
+	if len(tr.templates) == 0 {
+		return nil, nil
+	}
+
+	if defaultTopic != "" {
+		if ok, err := util.ValidateNatsTopic(defaultTopic); !ok {
+			return nil, errors.Wrap(err, "invalid default topic "+defaultTopic)
+		}
+		tr.defaultTopic = defaultTopic
+	}
+	return tr, nil
+}
+
+func parseTopicTemplate(text string) (topicTemplate, error) {
+
+	tt := topicTemplate{text: text}
+	rest := text
+	for rest != "" {
+		i := strings.Index(rest, "{{")
+		if i < 0 {
+			tt.parts = append(tt.parts, templatePart{literal: rest})
+			break
+		}
+		if i > 0 {
+			tt.parts = append(tt.parts, templatePart{literal: rest[:i]})
+		}
+		rest = rest[i+2:]
+		j := strings.Index(rest, "}}")
+		if j < 0 {
+			return tt, errors.New("topic template " + text + " has an unclosed {{")
+		}
+		path := strings.TrimSpace(rest[:j])
+		if path == "" {
+			return tt, errors.New("topic template " + text + " has an empty {{}}")
+		}
+		tt.parts = append(tt.parts, templatePart{path: path})
+		rest = rest[j+2:]
+	}
+	return tt, nil
+}
+
+//
+// returns the topic for the message, topic is the topic
+// it goes to if no template matches and there is no default
+//
+func (tr *topicRouter) route(msg []byte, topic string) string {
+
+	if tr == nil {
+		return topic
+	}
+
+	for _, tt := range tr.templates {
+		t, ok := tt.render(msg)
+		if !ok {
+			continue
+		}
+		if valid, err := util.ValidateNatsTopic(t); !valid {
+			log.Printf("Warning: topic template %s gives invalid topic %s: %v\n", tt.text, t, err)
+			continue
+		}
+		return t
+	}
+
+	if tr.defaultTopic != "" {
+		return tr.defaultTopic
+	}
+	return topic
+}
+
+//
+// fills in the template from the message, false if any field
+// is missing or empty. characters that cannot be used in a
+// topic (anything but letters and digits) are removed from
+// field values, eg. Non-Government becomes NonGovernment.
+//
+func (tt topicTemplate) render(msg []byte) (string, bool) {
+
+	var sb strings.Builder
+	for _, p := range tt.parts {
+		if p.path == "" {
+			sb.WriteString(p.literal)
+			continue
+		}
+		v := gjson.GetBytes(msg, p.path)
+		if !v.Exists() {
+			return "", false
+		}
+		s := topicToken(v.String())
+		if s == "" {
+			return "", false
+		}
+		sb.WriteString(s)
+	}
+	return sb.String(), true
+}
+
+func topicToken(s string) string {
+	return strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			return r
+		}
+		return -1
+	}, s)
+}
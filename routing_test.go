@@ -0,0 +1,67 @@
+package otfreader
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTopicRouter(t *testing.T) {
+
+	msg := []byte(`{
+		"meta": {"capability": "Literacy", "level": 3, "empty": "", "dashes": "--"},
+		"original": {"school": {"sector": "Non-Government", "name": "St. Mary's"}, "score": 1.5, "passed": true}
+	}`)
+
+	cases := []struct {
+		name     string
+		routes   string
+		fallback string
+		topic    string
+	}{
+		{"first template", "otf.ingest.{{meta.capability}}.{{original.school.sector}},otf.ingest.{{meta.capability}}", "", "otf.ingest.Literacy.NonGovernment"},
+		{"missing field", "otf.ingest.{{original.school.state}},otf.ingest.{{meta.capability}}", "", "otf.ingest.Literacy"},
+		{"empty field", "otf.ingest.{{meta.empty}},otf.ingest.{{meta.capability}}", "", "otf.ingest.Literacy"},
+		{"field with no topic characters", "otf.ingest.{{meta.dashes}},otf.ingest.{{meta.capability}}", "", "otf.ingest.Literacy"},
+		{"punctuation removed", "otf.{{original.school.name}}", "", "otf.StMarys"},
+		{"number and boolean values", "otf.{{meta.level}}.{{original.score}}.{{original.passed}}", "", "otf.3.15.true"},
+		{"spaces in template", "otf.ingest.{{ meta.capability }}", "", "otf.ingest.Literacy"},
+		{"whole topic from field", "{{meta.capability}}", "", "Literacy"},
+		{"invalid rendered topic", ".{{meta.capability}},otf.{{meta.level}}", "", "otf.3"},
+		{"no match uses default", "otf.{{original.missing}}", "otf.unrouted", "otf.unrouted"},
+		{"no match uses reader topic", "otf.{{original.missing}}", "", "otf.ingest"},
+		{"no templates", "", "otf.unrouted", "otf.ingest"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			tr, err := parseTopicRouter(c.routes, c.fallback)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if topic := tr.route(msg, "otf.ingest"); topic != c.topic {
+				t.Errorf("routed to %s, expected %s", topic, c.topic)
+			}
+		})
+	}
+}
+
+func TestParseTopicRouterErrors(t *testing.T) {
+
+	cases := []struct {
+		routes   string
+		fallback string
+		err      string
+	}{
+		{"otf.{{meta.capability", "", "unclosed {{"},
+		{"otf.{{ }}", "", "empty {{}}"},
+		{"otf.ingest,otf.{{meta.level", "", "unclosed {{"},
+		{"otf.{{meta.level}}", "otf.unrouted.", "invalid default topic"},
+	}
+
+	for _, c := range cases {
+		_, err := parseTopicRouter(c.routes, c.fallback)
+		if err == nil || !strings.Contains(err.Error(), c.err) {
+			t.Errorf("%q: error is %v, expected %q", c.routes, err, c.err)
+		}
+	}
+}
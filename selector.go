@@ -0,0 +1,186 @@
+package otfreader
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+//
+// a record selector picks the records to publish out of
+// nested json, using a small subset of jq path syntax:
+//
+// .              the whole document is the record
+// .[]            each member of the top-level array
+// .name          the named field of an object
+// .[].tests[]    each member of the tests array, of each top-level member
+//
+// carry-down fields are copied from the enclosing (parent) objects
+// into each selected record, so that data held once at a higher
+// level (eg. school details) is repeated in every record.
+//
+type recordSelector struct {
+	steps []selectorStep
+	carry []carryField
+}
+
+//
+// a single step in the selector path, either
+// a named field or iteration of an array
+//
+type selectorStep struct {
+	field   string
+	iterate bool
+}
+
+//
+// a field copied from a parent object (src) into the
+// selected record (dest), both are gjson/sjson dot paths
+//
+type carryField struct {
+	dest string
+	src  string
+}
+
+//
+// parses the selector expression and the comma-separated list
+// of carry-down fields, each given either as a plain path
+// (eg. school) or as dest=src (eg. test.name=name)
+//
+func parseRecordSelector(expr string, carry string) (*recordSelector, error) {
+
+	expr = strings.TrimSpace(expr)
+	if !strings.HasPrefix(expr, ".") {
+		return nil, errors.New("record selector must start with '.', eg. .[].allocations[]")
+	}
+
+	sel := &recordSelector{}
+	for i := 0; i < len(expr); {
+		switch {
+		case strings.HasPrefix(expr[i:], "[]"):
+			sel.steps = append(sel.steps, selectorStep{iterate: true})
+			i += 2
+		case expr[i] == '.':
+			i++
+			j := i
+			for j < len(expr) && expr[j] != '.' && expr[j] != '[' {
+				j++
+			}
+			name := expr[i:j]
+			if strings.ContainsAny(name, " \t|,()\"'") {
+				// other jq syntax, such as pipes
+				return nil, errors.Errorf("unsupported record selector syntax at '%s' (only .field and [] are supported)", expr[i:])
+			}
+			if name != "" {
+				sel.steps = append(sel.steps, selectorStep{field: name})
+			}
+			i = j
+		default:
+			return nil, errors.Errorf("unsupported record selector syntax at '%s' (only .field and [] are supported)", expr[i:])
+		}
+	}
+
+	for _, c := range strings.Split(carry, ",") {
+		c = strings.TrimSpace(c)
+		if c == "" {
+			continue
+		}
+		cf := carryField{dest: c, src: c}
+		if parts := strings.SplitN(c, "=", 2); len(parts) == 2 {
+			cf.dest = strings.TrimSpace(parts[0])
+			cf.src = strings.TrimSpace(parts[1])
+		}
+		if cf.dest == "" || cf.src == "" {
+			return nil, errors.Errorf("invalid carry-down field '%s'", c)
+		}
+		sel.carry = append(sel.carry, cf)
+	}
+
+	return sel, nil
+}
+
+//
+// true if the selector starts by iterating the top-level array,
+// in which case the array can be streamed rather than read whole
+//
+func (sel *recordSelector) streamsArray() bool {
+	return len(sel.steps) > 0 && sel.steps[0].iterate
+}
+
+//
+// applies the selector to a json document, handing
+// every selected record to the handler
+//
+func (sel *recordSelector) selectRecords(doc []byte, handler recordHandler) error {
+	return sel.walk(doc, sel.steps, nil, handler)
+}
+
+//
+// applies the selector to a member of the top-level array,
+// for use when the array is being streamed
+//
+func (sel *recordSelector) selectFromMember(member []byte, handler recordHandler) error {
+	return sel.walk(member, sel.steps[1:], nil, handler)
+}
+
+func (sel *recordSelector) walk(v []byte, steps []selectorStep, parents [][]byte, handler recordHandler) error {
+
+	if len(steps) == 0 {
+		return sel.emit(v, parents, handler)
+	}
+
+	step := steps[0]
+	if !step.iterate {
+		res := gjson.GetBytes(v, gjsonEscape(step.field))
+		if !res.Exists() {
+			return nil
+		}
+		// the object holding the field is a parent of any records below it
+		return sel.walk([]byte(res.Raw), steps[1:], append(parents, v), handler)
+	}
+
+	var err error
+	gjson.ParseBytes(v).ForEach(func(_, member gjson.Result) bool {
+		err = sel.walk([]byte(member.Raw), steps[1:], parents, handler)
+		return err == nil
+	})
+	return err
+}
+
+//
+// copies any carry-down fields into the record, looking
+// in the nearest parent first, then publishes it
+//
+func (sel *recordSelector) emit(record []byte, parents [][]byte, handler recordHandler) error {
+
+	if len(sel.carry) > 0 && gjson.ParseBytes(record).IsObject() {
+		// work on a copy, the record is a slice of its parent document
+		record = append([]byte(nil), record...)
+		for _, cf := range sel.carry {
+			for i := len(parents) - 1; i >= 0; i-- {
+				res := gjson.GetBytes(parents[i], cf.src)
+				if !res.Exists() {
+					continue
+				}
+				var err error
+				record, err = sjson.SetRawBytes(record, cf.dest, []byte(res.Raw))
+				if err != nil {
+					return errors.Wrapf(err, "cannot carry down field %s", cf.src)
+				}
+				break
+			}
+		}
+	}
+
+	return handler(record)
+}
+
+//
+// escapes gjson path characters in a plain field name
+//
+func gjsonEscape(field string) string {
+	r := strings.NewReplacer(".", `\.`, "*", `\*`, "?", `\?`)
+	return r.Replace(field)
+}
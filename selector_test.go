@@ -0,0 +1,157 @@
+package otfreader
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRecordSelector(t *testing.T) {
+
+	// cut down from the brightpath export
+	brightPath := `[
+		{"school":{"name":"WA Demo School"},"name":"Test project","allocations":[
+			{"student":"Kandra","score":1},
+			{"student":"Mila","score":2}
+		]},
+		{"school":{"name":"Other School"},"name":"Second project","allocations":[
+			{"student":"Ava","score":3}
+		]},
+		{"school":{"name":"No Allocations"},"name":"Empty"}
+	]`
+
+	cases := []struct {
+		name     string
+		selector string
+		carry    string
+		input    string
+		records  []string
+	}{
+		{
+			name:     "brightpath allocations",
+			selector: ".[].allocations[]",
+			carry:    "school,test.name=name",
+			input:    brightPath,
+			records: []string{
+				`{"student":"Kandra","score":1,"school":{"name":"WA Demo School"},"test":{"name":"Test project"}}`,
+				`{"student":"Mila","score":2,"school":{"name":"WA Demo School"},"test":{"name":"Test project"}}`,
+				`{"student":"Ava","score":3,"school":{"name":"Other School"},"test":{"name":"Second project"}}`,
+			},
+		},
+		{
+			name:     "no carry-down fields",
+			selector: ".[].allocations[]",
+			input:    brightPath,
+			records: []string{
+				`{"student":"Kandra","score":1}`,
+				`{"student":"Mila","score":2}`,
+				`{"student":"Ava","score":3}`,
+			},
+		},
+		{
+			name:     "object document",
+			selector: ".data.results[]",
+			carry:    "batch",
+			input:    `{"batch":7,"data":{"results":[{"id":1},{"id":2}]}}`,
+			records:  []string{`{"id":1,"batch":7}`, `{"id":2,"batch":7}`},
+		},
+		{
+			name:     "nearest parent first",
+			selector: ".classes[].students[]",
+			carry:    "class=name,school=name,term",
+			input:    `{"name":"School","term":3,"classes":[{"name":"7A","students":[{"id":1}]}]}`,
+			records:  []string{`{"id":1,"class":"7A","school":"7A","term":3}`},
+		},
+		{
+			name:     "missing carry-down field",
+			selector: ".[].allocations[]",
+			carry:    "district",
+			input:    `[{"allocations":[{"id":1}]}]`,
+			records:  []string{`{"id":1}`},
+		},
+		{
+			name:     "records that are not objects",
+			selector: ".[].years[]",
+			carry:    "name",
+			input:    `[{"name":"Test","years":["Year 1","Year 2"]}]`,
+			records:  []string{`"Year 1"`, `"Year 2"`},
+		},
+		{
+			name:     "whole document",
+			selector: ".",
+			input:    `{"id":1}`,
+			records:  []string{`{"id":1}`},
+		},
+		{
+			name:     "field with a dot in its name",
+			selector: ".[].allocations[]",
+			input:    `[{"allocations":[{"a.b":1}]}]`,
+			records:  []string{`{"a.b":1}`},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			sel, err := parseRecordSelector(c.selector, c.carry)
+			if err != nil {
+				t.Fatal(err)
+			}
+			records := []string{}
+			handler := func(m []byte, meta ...metaField) error {
+				records = append(records, string(m))
+				return nil
+			}
+			if err := readJSON(strings.NewReader(c.input), sel, handler); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(records, c.records) {
+				t.Errorf("records are\n%v\nexpected\n%v", records, c.records)
+			}
+		})
+	}
+}
+
+func TestRecordSelectorNDJSON(t *testing.T) {
+
+	sel, err := parseRecordSelector(".allocations[]", "school")
+	if err != nil {
+		t.Fatal(err)
+	}
+	input := `{"school":"A","allocations":[{"id":1},{"id":2}]}
+{"school":"B","allocations":[{"id":3}]}
+`
+	records := []string{}
+	handler := func(m []byte, meta ...metaField) error {
+		records = append(records, string(m))
+		return nil
+	}
+	if err := readNDJSON(strings.NewReader(input), "test.ndjson", sel, handler); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{`{"id":1,"school":"A"}`, `{"id":2,"school":"A"}`, `{"id":3,"school":"B"}`}
+	if !reflect.DeepEqual(records, expected) {
+		t.Errorf("records are\n%v\nexpected\n%v", records, expected)
+	}
+}
+
+func TestParseRecordSelectorErrors(t *testing.T) {
+
+	cases := []struct {
+		selector string
+		carry    string
+		err      string
+	}{
+		{"allocations[]", "", "must start with '.'"},
+		{".[].allocations | .[]", "", "unsupported record selector syntax"},
+		{".[0]", "", "unsupported record selector syntax"},
+		{".[]", "=name", "invalid carry-down field"},
+		{".[]", "test.name=", "invalid carry-down field"},
+	}
+
+	for _, c := range cases {
+		_, err := parseRecordSelector(c.selector, c.carry)
+		if err == nil || !strings.Contains(err.Error(), c.err) {
+			t.Errorf("%q %q: error is %v, expected %q", c.selector, c.carry, err, c.err)
+		}
+	}
+}
@@ -0,0 +1,282 @@
+package otfreader
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/radovskyb/watcher"
+)
+
+//
+// a set of file paths, such as the files the reader
+// is waiting on to be completely written
+//
+type pathSet struct {
+	mu    sync.Mutex
+	paths map[string]bool
+}
+
+func newPathSet() *pathSet {
+	return &pathSet{paths: map[string]bool{}}
+}
+
+//
+// adds the path, false if it was already in the set
+//
+func (ps *pathSet) add(path string) bool {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	if ps.paths[path] {
+		return false
+	}
+	ps.paths[path] = true
+	return true
+}
+
+//
+// removes the path, false if it was not in the set
+//
+func (ps *pathSet) remove(path string) bool {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	found := ps.paths[path]
+	delete(ps.paths, path)
+	return found
+}
+
+//
+// waits in the background for the file to settle (see
+// Settle), then publishes it once a worker is free.
+// further events for a file that is already waiting are
+// ignored, so a file still being copied is only read once.
+//
+func (rdr *OtfReader) publishWhenSettled(fileName string) {
+
+	if !rdr.settling.add(fileName) {
+		return
+	}
+
+	go func() {
+		ok := rdr.waitSettled(fileName)
+		rdr.settling.remove(fileName)
+		if !ok {
+			return
+		}
+		rdr.workers <- struct{}{} // acquire pool slot
+		if err := rdr.publishFile(fileName, false); err != nil {
+			log.Println("error publishing file: ", fileName, err)
+		}
+		<-rdr.workers // release slot back to pool
+	}()
+}
+
+//
+// blocks until the file is ready to read, checking once every
+// poll interval. returns false if the file is removed (or
+// renamed) while waiting, or the watcher is closed.
+// the manifest of a oneroster bundle folder is only ready once
+// the entity files it lists are there and have settled too.
+//
+func (rdr *OtfReader) waitSettled(fileName string) bool {
+
+	var last map[string]os.FileInfo
+	unchanged := 0
+	waiting := ""
+	for {
+		if _, err := os.Stat(fileName); err != nil {
+			return false
+		}
+		if rdr.settleMode == "none" {
+			return true
+		}
+
+		infos, missing := statFiles(append([]string{fileName}, rdr.bundleFiles(fileName)...))
+		switch {
+		case missing != "":
+			unchanged = 0
+			if waiting != missing {
+				fmt.Fprintf(rdr.out, "waiting for %s, listed in %s\n", missing, fileName)
+				waiting = missing
+			}
+		case rdr.settleMode == "marker":
+			if rdr.hasMarker(fileName) {
+				return true
+			}
+			if waiting != fileName {
+				fmt.Fprintf(rdr.out, "waiting for %s to be marked as ready (%s)\n", fileName, strings.Join(rdr.readyMarkers, " or "))
+				waiting = fileName
+			}
+		default:
+			if sameFiles(infos, last) {
+				unchanged++
+			} else {
+				unchanged = 0
+			}
+			if unchanged >= rdr.settleChecks {
+				return true
+			}
+		}
+		last = infos
+
+		select {
+		case <-time.After(rdr.interval):
+		case <-rdr.watcher.Closed:
+			return false
+		}
+	}
+}
+
+//
+// true if an existing file can be read straight away, used
+// for the backlog so that files already in the folder are
+// not each held up for the settle time. in stable mode a
+// file is taken as settled if it has not been modified for
+// the settle time.
+//
+func (rdr *OtfReader) settledNow(fileName string) bool {
+
+	if rdr.settleMode == "none" {
+		return true
+	}
+	infos, missing := statFiles(append([]string{fileName}, rdr.bundleFiles(fileName)...))
+	if missing != "" {
+		return false
+	}
+	if rdr.settleMode == "marker" {
+		return rdr.hasMarker(fileName)
+	}
+	for _, fi := range infos {
+		if time.Since(fi.ModTime()) <= time.Duration(rdr.settleChecks)*rdr.interval {
+			return false
+		}
+	}
+	return true
+}
+
+//
+// the file info of each file, and the first that is missing
+//
+func statFiles(files []string) (map[string]os.FileInfo, string) {
+	infos := map[string]os.FileInfo{}
+	for _, f := range files {
+		fi, err := os.Stat(f)
+		if err != nil {
+			return infos, f
+		}
+		infos[f] = fi
+	}
+	return infos, ""
+}
+
+//
+// true if the same files have the same size and mod time
+//
+func sameFiles(a, b map[string]os.FileInfo) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for f, fi := range a {
+		prev, ok := b[f]
+		if !ok || fi.Size() != prev.Size() || !fi.ModTime().Equal(prev.ModTime()) {
+			return false
+		}
+	}
+	return true
+}
+
+//
+// true if there is a ready marker for the file, either
+// alongside it (results.csv.done) or in place of its
+// extension (results.done)
+//
+func (rdr *OtfReader) hasMarker(fileName string) bool {
+	for _, p := range rdr.markerPaths(fileName) {
+		if _, err := os.Stat(p); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+//
+// removes the ready markers of a file once it has been
+// read, so that the next upload of the file waits for a
+// new marker rather than being read while it is written
+//
+func (rdr *OtfReader) removeMarkers(fileName string) {
+	if rdr.settleMode != "marker" {
+		return
+	}
+	for _, p := range rdr.markerPaths(fileName) {
+		if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+			log.Printf("Warning: unable to remove ready marker %s: %v\n", p, err)
+		}
+	}
+}
+
+func (rdr *OtfReader) markerPaths(fileName string) []string {
+	base := strings.TrimSuffix(fileName, filepath.Ext(fileName))
+	paths := []string{}
+	for _, m := range rdr.readyMarkers {
+		paths = append(paths, fileName+m, base+m)
+	}
+	return paths
+}
+
+//
+// true for files that are never read: temp files still being
+// uploaded, and in marker mode the marker files themselves
+//
+func (rdr *OtfReader) ignoredFile(fileName string) bool {
+	if rdr.isTempFile(fileName) {
+		return true
+	}
+	return rdr.settleMode == "marker" && hasSuffix(fileName, rdr.readyMarkers)
+}
+
+func (rdr *OtfReader) isTempFile(fileName string) bool {
+	return hasSuffix(fileName, rdr.tempSuffixes)
+}
+
+//
+// true if the event is a temp file being renamed to its final
+// name, the usual way for uploads to mark they are complete
+//
+func (rdr *OtfReader) renamedFromTemp(event watcher.Event) bool {
+	if event.Op != watcher.Rename && event.Op != watcher.Move {
+		return false
+	}
+	return rdr.isTempFile(event.OldPath) && !rdr.isTempFile(event.Path)
+}
+
+func hasSuffix(fileName string, suffixes []string) bool {
+	name := strings.ToLower(filepath.Base(fileName))
+	for _, s := range suffixes {
+		if strings.HasSuffix(name, s) {
+			return true
+		}
+	}
+	return false
+}
+
+//
+// splits a comma-separated list of file suffixes, each
+// lower-cased and given a leading dot, eg. "part, .TMP"
+// becomes [.part .tmp]
+//
+func parseSuffixes(list string) []string {
+	suffixes := []string{}
+	for _, s := range strings.Split(list, ",") {
+		s = strings.Trim(strings.TrimSpace(s), ".")
+		if s == "" {
+			continue
+		}
+		suffixes = append(suffixes, "."+strings.ToLower(s))
+	}
+	return suffixes
+}
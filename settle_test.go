@@ -0,0 +1,224 @@
+package otfreader
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/radovskyb/watcher"
+)
+
+func newSettleReader(t *testing.T, mode string) *OtfReader {
+	rdr := newTestReader(t, &memPublisher{})
+	rdr.settleMode = mode
+	rdr.interval = 10 * time.Millisecond
+	rdr.watcher = watcher.New()
+	t.Cleanup(rdr.watcher.Close)
+	return rdr
+}
+
+func TestParseSuffixes(t *testing.T) {
+
+	cases := map[string][]string{
+		"":                {},
+		"part, .TMP":      {".part", ".tmp"},
+		" .done,,ready. ": {".done", ".ready"},
+	}
+	for list, expected := range cases {
+		if suffixes := parseSuffixes(list); !reflect.DeepEqual(suffixes, expected) {
+			t.Errorf("%q gives %v, expected %v", list, suffixes, expected)
+		}
+	}
+}
+
+func TestIgnoredFile(t *testing.T) {
+
+	cases := []struct {
+		mode    string
+		file    string
+		ignored bool
+	}{
+		{"stable", "/in/results.csv", false},
+		{"stable", "/in/results.csv.part", true},
+		{"stable", "/in/results.csv.TMP", true},
+		{"stable", "/in/results.csv.done", false},
+		{"marker", "/in/results.csv.done", true},
+		{"marker", "/in/results.ready", true},
+		{"marker", "/in/results.csv", false},
+		{"none", "/in/results.part", true},
+	}
+	for _, c := range cases {
+		rdr := newSettleReader(t, c.mode)
+		if ignored := rdr.ignoredFile(c.file); ignored != c.ignored {
+			t.Errorf("%s in %s mode ignored is %v, expected %v", c.file, c.mode, ignored, c.ignored)
+		}
+	}
+}
+
+func TestRenamedFromTemp(t *testing.T) {
+
+	cases := []struct {
+		event   watcher.Event
+		renamed bool
+	}{
+		{watcher.Event{Op: watcher.Rename, OldPath: "/in/a.csv.part", Path: "/in/a.csv"}, true},
+		{watcher.Event{Op: watcher.Move, OldPath: "/in/tmp/a.tmp", Path: "/in/a.csv"}, true},
+		{watcher.Event{Op: watcher.Rename, OldPath: "/in/a.csv", Path: "/in/b.csv"}, false},
+		{watcher.Event{Op: watcher.Rename, OldPath: "/in/a.part", Path: "/in/b.tmp"}, false},
+		{watcher.Event{Op: watcher.Create, Path: "/in/a.csv"}, false},
+	}
+	rdr := newSettleReader(t, "stable")
+	for _, c := range cases {
+		if renamed := rdr.renamedFromTemp(c.event); renamed != c.renamed {
+			t.Errorf("%v renamed from temp is %v, expected %v", c.event, renamed, c.renamed)
+		}
+	}
+}
+
+func TestWaitSettled(t *testing.T) {
+
+	cases := []struct {
+		name    string
+		mode    string
+		action  func(path string) // run while waiting
+		settled bool
+		min     time.Duration // least time to settle
+	}{
+		{name: "none", mode: "none", settled: true},
+		{name: "stable", mode: "stable", settled: true, min: 20 * time.Millisecond},
+		{
+			name: "stable while written",
+			mode: "stable",
+			action: func(path string) {
+				f, _ := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+				defer f.Close()
+				for i := 0; i < 10; i++ {
+					f.WriteString("4,Alan\n")
+					time.Sleep(10 * time.Millisecond)
+				}
+			},
+			settled: true,
+			min:     100 * time.Millisecond,
+		},
+		{
+			name: "marker",
+			mode: "marker",
+			action: func(path string) {
+				time.Sleep(100 * time.Millisecond)
+				ioutil.WriteFile(path+".done", nil, 0644)
+			},
+			settled: true,
+			min:     100 * time.Millisecond,
+		},
+		{
+			name: "marker in place of extension",
+			mode: "marker",
+			action: func(path string) {
+				ioutil.WriteFile(path[:len(path)-len(".csv")]+".ready", nil, 0644)
+			},
+			settled: true,
+		},
+		{
+			name: "removed",
+			mode: "marker",
+			action: func(path string) {
+				time.Sleep(50 * time.Millisecond)
+				os.Remove(path)
+			},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			rdr := newSettleReader(t, c.mode)
+			path := writeTestFile(t, "results.csv", testCSV)
+			if c.action != nil {
+				go c.action(path)
+			}
+
+			start := time.Now()
+			done := make(chan bool, 1)
+			go func() { done <- rdr.waitSettled(path) }()
+			select {
+			case settled := <-done:
+				if settled != c.settled {
+					t.Errorf("settled is %v, expected %v", settled, c.settled)
+				}
+				if time.Since(start) < c.min {
+					t.Errorf("settled after %v, expected at least %v", time.Since(start), c.min)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("did not settle")
+			}
+		})
+	}
+}
+
+func TestWaitSettledClosed(t *testing.T) {
+
+	rdr := newSettleReader(t, "marker")
+	go rdr.watcher.Start(rdr.interval)
+	rdr.watcher.Wait()
+	path := writeTestFile(t, "results.csv", testCSV)
+	done := make(chan bool, 1)
+	go func() { done <- rdr.waitSettled(path) }()
+	time.Sleep(20 * time.Millisecond)
+	rdr.watcher.Close()
+	select {
+	case settled := <-done:
+		if settled {
+			t.Error("settled once the watcher closed")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("still waiting once the watcher closed")
+	}
+}
+
+func TestSettledNow(t *testing.T) {
+
+	path := writeTestFile(t, "results.csv", testCSV)
+
+	rdr := newSettleReader(t, "stable")
+	rdr.interval = time.Hour
+	if rdr.settledNow(path) {
+		t.Error("just written file is settled")
+	}
+	rdr.interval = time.Millisecond
+	time.Sleep(10 * time.Millisecond)
+	if !rdr.settledNow(path) {
+		t.Error("old file is not settled")
+	}
+
+	rdr = newSettleReader(t, "marker")
+	if rdr.settledNow(path) {
+		t.Error("settled with no marker")
+	}
+	ioutil.WriteFile(path+".ready", nil, 0644)
+	if !rdr.settledNow(path) {
+		t.Error("not settled with marker")
+	}
+}
+
+func TestMarkersRemoved(t *testing.T) {
+
+	rdr := newSettleReader(t, "marker")
+	path := writeTestFile(t, "results.csv", testCSV)
+	markers := []string{path + ".done", filepath.Join(filepath.Dir(path), "results.ready")}
+	for _, m := range markers {
+		ioutil.WriteFile(m, nil, 0644)
+	}
+	if err := publishTestFile(t, rdr, path); err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range markers {
+		if fileExists(m) {
+			t.Errorf("marker %s not removed", m)
+		}
+	}
+	if !fileExists(path) {
+		t.Error("file removed")
+	}
+}
@@ -0,0 +1,151 @@
+package otfreader
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+//
+// controls how the text values of csv (and xlsx) cells
+// are written into the json of each record.
+// by default every value is a json string.
+//
+type columnTypes struct {
+	infer  bool              // guess numbers, booleans and nulls
+	schema map[string]string // explicit type for named columns
+}
+
+//
+// the types that can be given to a column in the schema
+//
+var supportedColumnTypes = map[string]bool{
+	"string":  true,
+	"number":  true,
+	"integer": true,
+	"boolean": true,
+}
+
+//
+// parses a comma-separated list of column:type pairs,
+// eg. score:number,passed:boolean,studentID:string
+//
+func parseColumnTypes(infer bool, schema string) (*columnTypes, error) {
+
+	ct := &columnTypes{infer: infer, schema: map[string]string{}}
+	for _, entry := range strings.Split(schema, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		i := strings.LastIndex(entry, ":")
+		if i <= 0 {
+			return nil, errors.Errorf("column type '%s' must be given as column:type", entry)
+		}
+		col := strings.TrimSpace(entry[:i])
+		typ := strings.ToLower(strings.TrimSpace(entry[i+1:]))
+		if !supportedColumnTypes[typ] {
+			return nil, errors.Errorf("column type '%s' for %s not supported (must be one of string|number|integer|boolean)", typ, col)
+		}
+		ct.schema[col] = typ
+	}
+
+	if !ct.infer && len(ct.schema) == 0 {
+		return nil, nil
+	}
+	return ct, nil
+}
+
+//
+// writes the cell value as json, typed according to the schema
+// for the column, or by inference if enabled.
+// empty cells in typed columns are written as null.
+// values that cannot be converted to the schema type are kept
+// as strings, so that no data is lost.
+//
+func (ct *columnTypes) writeValue(buf *bytes.Buffer, column string, v string) {
+
+	if ct == nil {
+		writeJSONString(buf, v)
+		return
+	}
+
+	typ, ok := ct.schema[column]
+	if !ok && !ct.infer {
+		writeJSONString(buf, v)
+		return
+	}
+
+	tv := strings.TrimSpace(v)
+	if typ == "string" {
+		writeJSONString(buf, v)
+		return
+	}
+	if tv == "" {
+		buf.WriteString("null")
+		return
+	}
+
+	switch typ {
+	case "number":
+		if jsonNumber(tv) {
+			buf.WriteString(tv)
+			return
+		}
+		if f, err := strconv.ParseFloat(tv, 64); err == nil && !math.IsInf(f, 0) && !math.IsNaN(f) {
+			buf.WriteString(strconv.FormatFloat(f, 'f', -1, 64))
+			return
+		}
+	case "integer":
+		if i, err := strconv.ParseInt(tv, 10, 64); err == nil {
+			buf.WriteString(strconv.FormatInt(i, 10))
+			return
+		}
+	case "boolean":
+		if b, err := strconv.ParseBool(tv); err == nil {
+			buf.WriteString(strconv.FormatBool(b))
+			return
+		}
+		switch strings.ToLower(tv) {
+		case "yes", "y":
+			buf.WriteString("true")
+			return
+		case "no", "n":
+			buf.WriteString("false")
+			return
+		}
+	default:
+		// inferred; only values that are unambiguously numbers or
+		// booleans are converted, so that codes such as 007 stay as strings
+		if jsonNumber(tv) {
+			buf.WriteString(tv)
+			return
+		}
+		switch strings.ToLower(tv) {
+		case "true", "false":
+			buf.WriteString(strings.ToLower(tv))
+			return
+		}
+		writeJSONString(buf, v)
+		return
+	}
+
+	log.Printf("Warning: column %s value '%s' is not a valid %s, kept as string\n", column, v, typ)
+	writeJSONString(buf, v)
+}
+
+//
+// true if s is already a valid json number literal
+//
+func jsonNumber(s string) bool {
+	if s == "" || !(s[0] == '-' || (s[0] >= '0' && s[0] <= '9')) {
+		return false
+	}
+	var n json.Number
+	return json.Unmarshal([]byte(s), &n) == nil
+}
@@ -0,0 +1,101 @@
+package otfreader
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestColumnTypes(t *testing.T) {
+
+	cases := []struct {
+		name   string
+		infer  bool
+		schema string
+		column string
+		value  string
+		json   string
+	}{
+		{"untyped", false, "score:number", "name", "42", `"42"`},
+		{"inferred integer", true, "", "score", "42", `42`},
+		{"inferred negative float", true, "", "score", "-2.5e3", `-2.5e3`},
+		{"inferred padded number", true, "", "score", " 7 ", `7`},
+		{"inferred leading zero kept", true, "", "code", "007", `"007"`},
+		{"inferred boolean", true, "", "passed", "TRUE", `true`},
+		{"inferred yes kept", true, "", "passed", "yes", `"yes"`},
+		{"inferred empty", true, "", "score", "", `null`},
+		{"inferred text", true, "", "name", "Ada", `"Ada"`},
+		{"schema string wins over inference", true, "code:string", "code", "42", `"42"`},
+		{"schema string keeps empty", false, "code:string", "code", "", `""`},
+		{"number", false, "score:number", "score", "42.50", `42.50`},
+		{"number with plus sign", false, "score:number", "score", "+3", `3`},
+		{"number leading zero", false, "score:number", "score", "007", `7`},
+		{"not a number", false, "score:number", "score", "absent", `"absent"`},
+		{"infinity is not a number", false, "score:number", "score", "Inf", `"Inf"`},
+		{"integer", false, "year:integer", "year", " 2020 ", `2020`},
+		{"not an integer", false, "year:integer", "year", "2020.5", `"2020.5"`},
+		{"boolean", false, "passed:boolean", "passed", "1", `true`},
+		{"boolean yes", false, "passed:boolean", "passed", "Y", `true`},
+		{"boolean no", false, "passed:boolean", "passed", "no", `false`},
+		{"not a boolean", false, "passed:boolean", "passed", "maybe", `"maybe"`},
+		{"typed empty", false, "score:number", "score", "  ", `null`},
+		{"escaped string", true, "", "note", "say \"hi\"\n", `"say \"hi\"\n"`},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ct, err := parseColumnTypes(c.infer, c.schema)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var buf bytes.Buffer
+			ct.writeValue(&buf, c.column, c.value)
+			if buf.String() != c.json {
+				t.Errorf("%q is written as %s, expected %s", c.value, buf.String(), c.json)
+			}
+		})
+	}
+}
+
+func TestParseColumnTypes(t *testing.T) {
+
+	ct, err := parseColumnTypes(false, "")
+	if err != nil || ct != nil {
+		t.Errorf("no types gives %v %v, expected nil", ct, err)
+	}
+
+	ct, err = parseColumnTypes(false, " score : Number , a:b:integer,")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ct.schema["score"] != "number" || ct.schema["a:b"] != "integer" {
+		t.Errorf("schema is %v", ct.schema)
+	}
+
+	for _, schema := range []string{"score", ":number", "score:float"} {
+		if _, err := parseColumnTypes(false, schema); err == nil {
+			t.Errorf("%q is accepted", schema)
+		}
+	}
+}
+
+func TestReadCSVTypes(t *testing.T) {
+
+	ct, err := parseColumnTypes(true, "id:string")
+	if err != nil {
+		t.Fatal(err)
+	}
+	records := []string{}
+	handler := func(m []byte, meta ...metaField) error {
+		records = append(records, string(m))
+		return nil
+	}
+	input := "id,score,passed,note\n007,12.5,false,\n"
+	if err := readCSV(strings.NewReader(input), "test.csv", defaultCSVDialect, ct, handler); err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"id":"007","score":12.5,"passed":false,"note":null}`
+	if len(records) != 1 || records[0] != expected {
+		t.Errorf("records are %v, expected %s", records, expected)
+	}
+}
@@ -0,0 +1,113 @@
+package otfreader
+
+import (
+	"bytes"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/360EntSecGroup-Skylar/excelize/v2"
+	"github.com/pkg/errors"
+)
+
+//
+// reads a sheet of an excel (.xlsx) workbook, the header row
+// names the columns and every non-empty row below it is handed
+// to the record handler as a json object, just as for csv rows.
+// the sheet name and row number are added to the meta-data
+// so that records can be traced back to the spreadsheet.
+//
+// sheet can be a sheet name, or a 1-based sheet index,
+// an empty sheet selects the first sheet in the workbook.
+//
+func readXLSX(r io.Reader, fileName string, sheet string, headerRow int, types *columnTypes, handler recordHandler) error {
+
+	wb, err := excelize.OpenReader(r)
+	if err != nil {
+		return errors.Wrap(err, "unable to open xlsx workbook")
+	}
+
+	sheetName, err := xlsxSheetName(wb, sheet)
+	if err != nil {
+		return err
+	}
+
+	rows, err := wb.Rows(sheetName)
+	if err != nil {
+		return errors.Wrap(err, "unable to read sheet "+sheetName)
+	}
+
+	var header []string
+	var buf bytes.Buffer
+	rowNum := 0
+	for rows.Next() {
+		rowNum++
+		row, err := rows.Columns()
+		if err != nil {
+			return errors.Wrapf(err, "unable to read row %d of sheet %s", rowNum, sheetName)
+		}
+		if rowNum < headerRow {
+			continue
+		}
+		if rowNum == headerRow {
+			header = csvHeader(row, fileName)
+			continue
+		}
+		if blankRow(row) {
+			continue
+		}
+
+		header = extendHeader(header, len(row))
+		err = handler(rowJSON(&buf, header, row, types),
+			metaField{name: "sourceSheet", value: sheetName},
+			metaField{name: "sourceRow", value: rowNum},
+		)
+		if err != nil {
+			return err
+		}
+	}
+	if err := rows.Error(); err != nil {
+		return errors.Wrap(err, "unable to read rows of sheet "+sheetName)
+	}
+	if header == nil {
+		return errors.Errorf("sheet %s has no header row %d", sheetName, headerRow)
+	}
+
+	return nil
+}
+
+//
+// resolves the configured sheet (name or 1-based index)
+// to the name of a sheet in the workbook
+//
+func xlsxSheetName(wb *excelize.File, sheet string) (string, error) {
+
+	sheets := wb.GetSheetList()
+	if len(sheets) == 0 {
+		return "", errors.New("xlsx workbook has no sheets")
+	}
+
+	sheet = strings.TrimSpace(sheet)
+	if sheet == "" {
+		return sheets[0], nil
+	}
+	for _, s := range sheets {
+		if s == sheet {
+			return s, nil
+		}
+	}
+	if i, err := strconv.Atoi(sheet); err == nil && i >= 1 && i <= len(sheets) {
+		return sheets[i-1], nil
+	}
+
+	return "", errors.Errorf("sheet %s not found in workbook (sheets are: %s)", sheet, strings.Join(sheets, ", "))
+}
+
+func blankRow(row []string) bool {
+	for _, v := range row {
+		if strings.TrimSpace(v) != "" {
+			return false
+		}
+	}
+	return true
+}
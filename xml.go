@@ -0,0 +1,243 @@
+package otfreader
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"io"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+//
+// reads an xml file as a stream, every element found at the
+// given record path (eg. /StudentPersonals/StudentPersonal) is
+// converted to json and handed to the record handler.
+//
+// xml is converted to json using the following convention:
+//
+// - the record element itself becomes the top-level json object
+// - attributes are members named with an @ prefix, eg. "@RefId"
+// - child elements are members named by their (prefixed) element name
+// - repeated child elements of the same name become an array, in document order
+// - an element with no attributes or children becomes a plain string of its text
+// - otherwise any text content is held in a "#text" member
+// - for mixed content (text alongside child elements) "#text" joins
+//   the text segments (trimmed), and a "#content" array holds the text segments
+//   and child elements (as single member objects) in document order,
+//   eg. "Jo <b>x</b> Bloggs" gives ["Jo ", {"b": "x"}, " Bloggs"]
+// - namespace prefixes are kept as written, eg. "xsi:nil", "@xmlns:xsi"
+//
+// text is kept as is, so "<Name> Smith </Name>" gives " Smith ",
+// apart from whitespace between the child elements of an element
+// with no other text (indentation), which is dropped.
+//
+func readXML(r io.Reader, recordPath string, handler recordHandler) error {
+
+	target := splitXMLPath(recordPath)
+	if len(target) == 0 {
+		return errors.New("xml record path cannot be empty")
+	}
+
+	d := xml.NewDecoder(r)
+	// input has already been transcoded to utf-8, so
+	// any encoding declared in the xml header is ignored
+	d.CharsetReader = func(_ string, in io.Reader) (io.Reader, error) {
+		return in, nil
+	}
+	path := []string{}
+	for {
+		// raw tokens are used so that namespace prefixes
+		// are preserved as they appear in the file
+		tok, err := d.RawToken()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return errors.Wrap(err, "unable to read xml token")
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			path = append(path, xmlName(t.Name))
+			if !matchXMLPath(path, target) {
+				continue
+			}
+			v, err := readXMLElement(d, t)
+			if err != nil {
+				return errors.Wrap(err, "unable to decode xml record")
+			}
+			path = path[:len(path)-1]
+			if _, ok := v.(string); ok {
+				// always publish records as json objects
+				v = &xmlObject{keys: []string{"#text"}, vals: map[string]interface{}{"#text": v}}
+			}
+			m, err := json.Marshal(v)
+			if err != nil {
+				return errors.Wrap(err, "unable to convert xml record to json")
+			}
+			if err := handler(m); err != nil {
+				return err
+			}
+		case xml.EndElement:
+			if len(path) > 0 {
+				path = path[:len(path)-1]
+			}
+		}
+	}
+}
+
+//
+// consumes tokens up to the end of the given element,
+// returning either a string or an *xmlObject
+//
+func readXMLElement(d *xml.Decoder, start xml.StartElement) (interface{}, error) {
+
+	obj := &xmlObject{vals: map[string]interface{}{}}
+	for _, a := range start.Attr {
+		obj.add("@"+xmlName(a.Name), a.Value)
+	}
+
+	// text segments and child elements, in document order
+	content := []interface{}{}
+	var text bytes.Buffer
+	children, mixed := false, false
+	endText := func() {
+		if text.Len() > 0 {
+			content = append(content, text.String())
+			mixed = mixed || strings.TrimSpace(text.String()) != ""
+			text.Reset()
+		}
+	}
+
+	for {
+		tok, err := d.RawToken()
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			v, err := readXMLElement(d, t)
+			if err != nil {
+				return nil, err
+			}
+			name := xmlName(t.Name)
+			obj.add(name, v)
+			endText()
+			content = append(content, &xmlObject{keys: []string{name}, vals: map[string]interface{}{name: v}})
+			children = true
+		case xml.CharData:
+			text.Write(t)
+		case xml.EndElement:
+			if xmlName(t.Name) != xmlName(start.Name) {
+				return nil, errors.Errorf("element <%s> closed by </%s>", xmlName(start.Name), xmlName(t.Name))
+			}
+			if !children {
+				s := text.String()
+				if len(obj.keys) == 0 {
+					return s, nil
+				}
+				if s != "" {
+					obj.add("#text", s)
+				}
+				return obj, nil
+			}
+			endText()
+			if mixed {
+				// "#text" joins the text for convenience,
+				// "#content" keeps it in place
+				joined := ""
+				for _, c := range content {
+					if s, ok := c.(string); ok {
+						joined += s
+					}
+				}
+				obj.add("#text", strings.TrimSpace(joined))
+				obj.add("#content", content)
+			}
+			return obj, nil
+		}
+	}
+}
+
+//
+// json object that keeps members in xml document order
+//
+type xmlObject struct {
+	keys []string
+	vals map[string]interface{}
+}
+
+//
+// adds a member, repeated names are collected into an array
+//
+func (o *xmlObject) add(key string, v interface{}) {
+	existing, ok := o.vals[key]
+	if !ok {
+		o.keys = append(o.keys, key)
+		o.vals[key] = v
+		return
+	}
+	if arr, ok := existing.([]interface{}); ok {
+		o.vals[key] = append(arr, v)
+		return
+	}
+	o.vals[key] = []interface{}{existing, v}
+}
+
+func (o *xmlObject) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, k := range o.keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		kb, err := json.Marshal(k)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(kb)
+		buf.WriteByte(':')
+		vb, err := json.Marshal(o.vals[k])
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(vb)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+func xmlName(n xml.Name) string {
+	if n.Space == "" {
+		return n.Local
+	}
+	return n.Space + ":" + n.Local
+}
+
+func splitXMLPath(p string) []string {
+	parts := []string{}
+	for _, s := range strings.Split(p, "/") {
+		if s = strings.TrimSpace(s); s != "" {
+			parts = append(parts, s)
+		}
+	}
+	return parts
+}
+
+func matchXMLPath(path, target []string) bool {
+	if len(path) != len(target) {
+		return false
+	}
+	for i := range path {
+		if path[i] != target[i] {
+			return false
+		}
+	}
+	return true
+}
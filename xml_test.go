@@ -0,0 +1,62 @@
+package otfreader
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestReadXMLWhitespace(t *testing.T) {
+
+	cases := []struct {
+		name   string
+		input  string
+		record string
+	}{
+		{
+			name:   "leaf text kept as is",
+			input:  "<Names><Name><FamilyName> Smith </FamilyName><GivenName>Jo\n</GivenName></Name></Names>",
+			record: `{"FamilyName":" Smith ","GivenName":"Jo\n"}`,
+		},
+		{
+			name:   "leaf text with attributes",
+			input:  `<Names><Name><FamilyName Type="LGL"> Smith </FamilyName></Name></Names>`,
+			record: `{"FamilyName":{"@Type":"LGL","#text":" Smith "}}`,
+		},
+		{
+			name:   "indentation dropped",
+			input:  "<Names>\n  <Name>\n    <FamilyName>Smith</FamilyName>\n  </Name>\n</Names>",
+			record: `{"FamilyName":"Smith"}`,
+		},
+		{
+			name:   "mixed content kept in order",
+			input:  "<Names><Name> Jo <FamilyName>Smith</FamilyName> Bloggs <b/></Name></Names>",
+			record: `{"FamilyName":"Smith","b":"","#text":"Jo  Bloggs","#content":[" Jo ",{"FamilyName":"Smith"}," Bloggs ",{"b":""}]}`,
+		},
+		{
+			name:   "repeated children in mixed content",
+			input:  "<Names><Name>a<i>1</i>b<u>2</u>c<i>3</i></Name></Names>",
+			record: `{"i":["1","3"],"u":"2","#text":"abc","#content":["a",{"i":"1"},"b",{"u":"2"},"c",{"i":"3"}]}`,
+		},
+		{
+			name:   "cdata and entities",
+			input:  "<Names><Name><Note>1 &lt; 2 <![CDATA[<b>]]></Note></Name></Names>",
+			record: `{"Note":"1 \u003c 2 \u003cb\u003e"}`,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			records := []string{}
+			handler := func(m []byte, meta ...metaField) error {
+				records = append(records, string(m))
+				return nil
+			}
+			if err := readXML(strings.NewReader(c.input), "/Names/Name", handler); err != nil {
+				t.Fatal(err)
+			}
+			if len(records) != 1 || records[0] != c.record {
+				t.Errorf("records are %v, expected %s", records, c.record)
+			}
+		})
+	}
+}